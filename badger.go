@@ -0,0 +1,378 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// BadgerStore keeps entries in a Badger database, expiration is the native
+// Badger TTL which has a precision of one second
+type BadgerStore struct {
+	client            *badger.DB
+	gcDiscardRatio    float64
+	log               storeLogger
+	codec             Codec
+	stop              chan struct{}
+	closeOnce         sync.Once
+	DefaultExpiration time.Duration
+}
+
+type BadgerStoreOptions struct {
+	// Dir holds the LSM tree and the value log, it is ignored with InMemory
+	Dir string
+	// InMemory keeps everything in memory, nothing survives Close
+	InMemory bool
+	// SyncWrites syncs the value log on every write
+	SyncWrites bool
+
+	// GCInterval is the period of the value log garbage collection.
+	// Default is 5 minutes; -1 disables it.
+	GCInterval time.Duration
+	// GCDiscardRatio is the ratio of stale data above which a value log file is rewritten.
+	// Default is 0.5.
+	GCDiscardRatio float64
+
+	DefaultExpiration time.Duration
+
+	// Logger receives failed and slow operations and Badger warnings, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes and failures
+	Events *Events
+}
+
+var BadgerStoreOptionsDefault = &BadgerStoreOptions{
+	GCInterval:     5 * time.Minute,
+	GCDiscardRatio: 0.5,
+}
+
+// NewBadgerStore panics on invalid options, use OpenBadgerStore to handle them
+func NewBadgerStore(options *BadgerStoreOptions, opts ...Option) *BadgerStore {
+	store, err := OpenBadgerStore(options, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// OpenBadgerStore validates options and opens the database, nil options open
+// an in-memory database with BadgerStoreOptionsDefault
+func OpenBadgerStore(options *BadgerStoreOptions, opts ...Option) (*BadgerStore, error) {
+	if options == nil {
+		var inMemory = *BadgerStoreOptionsDefault
+		inMemory.InMemory = true
+		options = &inMemory
+	}
+
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+	}.apply("badger", opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case options.Dir == "" && !options.InMemory:
+		return nil, invalidOptions("badger", "Dir is required unless InMemory is set")
+	case options.GCDiscardRatio < 0 || options.GCDiscardRatio >= 1:
+		return nil, invalidOptions("badger", "GCDiscardRatio must be in [0, 1), got %v", options.GCDiscardRatio)
+	}
+
+	var dir = options.Dir
+	if options.InMemory {
+		dir = ""
+	}
+	client, err := badger.Open(badger.DefaultOptions(dir).
+		WithInMemory(options.InMemory).
+		WithSyncWrites(options.SyncWrites).
+		WithLogger(badgerLogger{config.Logger}))
+	if err != nil {
+		return nil, err
+	}
+
+	var store = &BadgerStore{
+		client:            client,
+		gcDiscardRatio:    options.GCDiscardRatio,
+		log:               newStoreLogger("badger", config.Logger, config.SlowThreshold, config.Events),
+		codec:             config.Codec,
+		stop:              make(chan struct{}),
+		DefaultExpiration: config.DefaultExpiration,
+	}
+	if store.gcDiscardRatio == 0 {
+		store.gcDiscardRatio = BadgerStoreOptionsDefault.GCDiscardRatio
+	}
+
+	var interval = options.GCInterval
+	if interval == 0 {
+		interval = BadgerStoreOptionsDefault.GCInterval
+	}
+	// The value log of an in-memory database cannot be collected
+	if interval > 0 && !options.InMemory {
+		go store.collector(interval)
+	}
+
+	return store, nil
+}
+
+func (c *BadgerStore) Get(key string, value interface{}) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+func (c *BadgerStore) GetBytes(key string) (value []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
+	err = c.client.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	return value, badgerError(err)
+}
+
+func (c *BadgerStore) Set(key string, value interface{}, expiration ...time.Duration) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return ErrMarshal
+	}
+
+	return c.SetBytes(key, bytes, expiration...)
+}
+
+// SetBytes stores bytes as is, an expiration under a second is rounded up to one second
+func (c *BadgerStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	return c.client.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badgerEntry(key, bytes, exp))
+	})
+}
+
+func (c *BadgerStore) Delete(key string) (err error) {
+	defer c.log.track("delete", key, time.Now(), &err)
+
+	return c.client.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+}
+
+func (c *BadgerStore) Exists(key string) (bool, error) {
+	err := c.client.View(func(txn *badger.Txn) error {
+		_, err := txn.Get([]byte(key))
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *BadgerStore) TTL(key string) (ttl time.Duration, err error) {
+	err = c.client.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+
+		ttl = NoExpiration
+		if expiresAt := item.ExpiresAt(); expiresAt > 0 {
+			ttl = time.Until(time.Unix(int64(expiresAt), 0))
+		}
+		return nil
+	})
+	return ttl, badgerError(err)
+}
+
+// Touch rewrites the entry with the new lifetime in a single transaction
+func (c *BadgerStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	err = c.client.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		return txn.SetEntry(badgerEntry(key, value, ttl))
+	})
+	return badgerError(err)
+}
+
+// Clear drops every entry, writes are blocked while it runs
+func (c *BadgerStore) Clear() (err error) {
+	defer c.log.track("clear", "", time.Now(), &err)
+
+	return c.client.DropAll()
+}
+
+// Scan iterates the keys in order without reading values, the cursor is the last key returned
+func (c *BadgerStore) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return nil, "", err
+	}
+
+	count = scanCount(count)
+	var keys []string
+	var next string
+	err = c.client.View(func(txn *badger.Txn) error {
+		var options = badger.DefaultIteratorOptions
+		options.PrefetchValues = false
+		var it = txn.NewIterator(options)
+		defer it.Close()
+
+		for it.Seek([]byte(cursor)); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var key = string(it.Item().Key())
+			if key <= cursor || !re.MatchString(key) {
+				continue
+			}
+			if len(keys) == count {
+				next = keys[count-1]
+				return nil
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return keys, next, nil
+}
+
+// Ping fails once the database is closed
+func (c *BadgerStore) Ping(ctx context.Context) error {
+	if c.client.IsClosed() {
+		return errors.New("cache: Badger database is closed")
+	}
+	return nil
+}
+
+// Close stops the value log garbage collection and closes the database
+func (c *BadgerStore) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		err = c.client.Close()
+	})
+	return err
+}
+
+// Codec returns the codec of values
+func (c *BadgerStore) Codec() Codec {
+	return c.codec
+}
+
+func (c *BadgerStore) Type() string {
+	return "badger"
+}
+
+// collector runs the value log garbage collection every interval until the store is closed
+func (c *BadgerStore) collector(interval time.Duration) {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.collect()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// collect rewrites value log files as long as one of them is worth it
+func (c *BadgerStore) collect() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+
+		var err = c.client.RunValueLogGC(c.gcDiscardRatio)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, badger.ErrNoRewrite) && !errors.Is(err, badger.ErrRejected) {
+			c.log.swallowed("gc", "", err)
+		}
+		return
+	}
+}
+
+func badgerEntry(key string, value []byte, expiration time.Duration) *badger.Entry {
+	var entry = badger.NewEntry([]byte(key), value)
+	if expiration <= 0 {
+		return entry
+	}
+	if expiration < time.Second {
+		expiration = time.Second
+	}
+	return entry.WithTTL(expiration)
+}
+
+func badgerError(err error) error {
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// badgerLogger forwards Badger warnings and errors to Logger, info and debug are dropped
+type badgerLogger struct {
+	logger Logger
+}
+
+func (l badgerLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Error("cache: badger: " + fmt.Sprintf(format, args...))
+}
+
+func (l badgerLogger) Warningf(format string, args ...interface{}) {
+	l.logger.Warn("cache: badger: " + fmt.Sprintf(format, args...))
+}
+
+func (l badgerLogger) Infof(format string, args ...interface{}) {}
+
+func (l badgerLogger) Debugf(format string, args ...interface{}) {}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBadgerStoreReopen(t *testing.T) {
+	var dir = t.TempDir()
+	var store = NewBadgerStore(&BadgerStoreOptions{Dir: dir, GCInterval: -1})
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_reopen", &strIn))
+	assert.NoError(t, store.Close())
+	assert.Error(t, store.Ping(context.Background()))
+
+	store = NewBadgerStore(&BadgerStoreOptions{Dir: dir, GCInterval: -1})
+	defer store.Close()
+
+	var strOut string
+	assert.NoError(t, store.Get("test_reopen", &strOut))
+	assert.Equal(t, strIn, strOut)
+}
+
+func TestBadgerStoreNilOptions(t *testing.T) {
+	store, err := OpenBadgerStore(nil)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_nil_options", &strIn))
+}
+
+func TestBadgerStoreTTL(t *testing.T) {
+	var store = NewBadgerStore(&BadgerStoreOptions{InMemory: true})
+	defer store.Close()
+
+	// Badger expiration has a precision of one second
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_ttl", &strIn, time.Millisecond))
+	found, err := store.Exists("test_ttl")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	assert.NoError(t, store.Touch("test_ttl", 0))
+	ttl, err := store.TTL("test_ttl")
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	assert.Equal(t, ErrKeyNotFound, store.Touch("test_ttl_missing", time.Minute))
+}
+
+func TestBadgerStoreScan(t *testing.T) {
+	var store = NewBadgerStore(&BadgerStoreOptions{InMemory: true})
+	defer store.Close()
+
+	var strIn = "Hello world"
+	for _, key := range []string{"user:1", "user:2", "user:3", "order:1"} {
+		assert.NoError(t, store.Set(key, &strIn))
+	}
+
+	keys, cursor, err := store.Scan(context.Background(), "user:*", "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+	assert.Equal(t, "user:2", cursor)
+
+	keys, cursor, err = store.Scan(context.Background(), "user:*", cursor, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:3"}, keys)
+	assert.Equal(t, "", cursor)
+
+	assert.NoError(t, store.Clear())
+	keys, _, err = store.Scan(context.Background(), "*", "", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
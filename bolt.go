@@ -0,0 +1,452 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore keeps entries in a bbolt file. bbolt has no expiration, every
+// value is prefixed with its expiration time and an index bucket orders the
+// expiring keys by time so the sweeper only visits expired entries
+type BoltStore struct {
+	client            *bolt.DB
+	bucket            []byte
+	expiry            []byte
+	log               storeLogger
+	codec             Codec
+	stop              chan struct{}
+	closeOnce         sync.Once
+	now               func() time.Time
+	DefaultExpiration time.Duration
+}
+
+type BoltStoreOptions struct {
+	// Path is the database file, it is created if missing
+	Path string
+	// Bucket holds the entries, Bucket + "_expiry" holds the expiration index. Default is "cache".
+	Bucket string
+	// Timeout is how long to wait for the file lock held by another process, 0 waits forever
+	Timeout time.Duration
+	// NoSync skips fsync on commit, writes are faster but may be lost on power failure
+	NoSync bool
+
+	// SweepInterval is the period of the removal of expired entries.
+	// Default is 1 minute; -1 disables it, expired entries are then only hidden from reads.
+	SweepInterval time.Duration
+
+	DefaultExpiration time.Duration
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes, evictions and failures
+	Events *Events
+}
+
+var BoltStoreOptionsDefault = &BoltStoreOptions{
+	Bucket:        "cache",
+	SweepInterval: time.Minute,
+}
+
+// NewBoltStore panics on invalid options, use OpenBoltStore to handle them
+func NewBoltStore(options *BoltStoreOptions, opts ...Option) *BoltStore {
+	store, err := OpenBoltStore(options, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// OpenBoltStore validates options, opens the file and creates the buckets
+func OpenBoltStore(options *BoltStoreOptions, opts ...Option) (*BoltStore, error) {
+	if options == nil {
+		return nil, invalidOptions("bolt", "options are required")
+	}
+
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+	}.apply("bolt", opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case options.Path == "":
+		return nil, invalidOptions("bolt", "Path is required")
+	case options.Timeout < 0:
+		return nil, invalidOptions("bolt", "Timeout must not be negative, got %v", options.Timeout)
+	}
+
+	var bucket = options.Bucket
+	if bucket == "" {
+		bucket = BoltStoreOptionsDefault.Bucket
+	}
+
+	client, err := bolt.Open(options.Path, 0o600, &bolt.Options{
+		Timeout: options.Timeout,
+		NoSync:  options.NoSync,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var store = &BoltStore{
+		client:            client,
+		bucket:            []byte(bucket),
+		expiry:            []byte(bucket + "_expiry"),
+		log:               newStoreLogger("bolt", config.Logger, config.SlowThreshold, config.Events),
+		codec:             config.Codec,
+		stop:              make(chan struct{}),
+		now:               time.Now,
+		DefaultExpiration: config.DefaultExpiration,
+	}
+	if err := client.Update(store.createBuckets); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	var interval = options.SweepInterval
+	if interval == 0 {
+		interval = BoltStoreOptionsDefault.SweepInterval
+	}
+	if interval > 0 {
+		go store.sweeper(interval)
+	}
+
+	return store, nil
+}
+
+func (c *BoltStore) Get(key string, value interface{}) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+func (c *BoltStore) GetBytes(key string) (value []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
+	err = c.client.View(func(tx *bolt.Tx) error {
+		stored, _, err := c.read(tx, key)
+		if err != nil {
+			return err
+		}
+		// Slices returned by bbolt are only valid during the transaction
+		value = append([]byte(nil), stored...)
+		return nil
+	})
+	return value, err
+}
+
+func (c *BoltStore) Set(key string, value interface{}, expiration ...time.Duration) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return ErrMarshal
+	}
+
+	return c.SetBytes(key, bytes, expiration...)
+}
+
+func (c *BoltStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	return c.client.Update(func(tx *bolt.Tx) error {
+		return c.write(tx, key, bytes, c.expiredAt(exp))
+	})
+}
+
+func (c *BoltStore) Delete(key string) (err error) {
+	defer c.log.track("delete", key, time.Now(), &err)
+
+	return c.client.Update(func(tx *bolt.Tx) error {
+		return c.remove(tx, key)
+	})
+}
+
+func (c *BoltStore) Exists(key string) (bool, error) {
+	err := c.client.View(func(tx *bolt.Tx) error {
+		_, _, err := c.read(tx, key)
+		return err
+	})
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *BoltStore) TTL(key string) (ttl time.Duration, err error) {
+	err = c.client.View(func(tx *bolt.Tx) error {
+		_, expiredAt, err := c.read(tx, key)
+		if err != nil {
+			return err
+		}
+
+		ttl = NoExpiration
+		if expiredAt > 0 {
+			ttl = time.Duration(expiredAt - c.now().UnixNano())
+		}
+		return nil
+	})
+	return ttl, err
+}
+
+// Touch rewrites the entry with the new lifetime in a single transaction
+func (c *BoltStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	return c.client.Update(func(tx *bolt.Tx) error {
+		value, _, err := c.read(tx, key)
+		if err != nil {
+			return err
+		}
+		return c.write(tx, key, append([]byte(nil), value...), c.expiredAt(ttl))
+	})
+}
+
+// Clear drops and recreates the buckets
+func (c *BoltStore) Clear() (err error) {
+	defer c.log.track("clear", "", time.Now(), &err)
+
+	return c.client.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{c.bucket, c.expiry} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		return c.createBuckets(tx)
+	})
+}
+
+// Scan iterates the keys in order and skips expired entries, the cursor is the last key returned
+func (c *BoltStore) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return nil, "", err
+	}
+
+	count = scanCount(count)
+	var now = c.now().UnixNano()
+	var keys []string
+	var next string
+	err = c.client.View(func(tx *bolt.Tx) error {
+		var it = tx.Bucket(c.bucket).Cursor()
+		for k, v := it.Seek([]byte(cursor)); k != nil; k, v = it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			var key = string(k)
+			if key <= cursor || !re.MatchString(key) {
+				continue
+			}
+			if expiredAt, _, ok := decodeBoltValue(v); !ok || boltExpired(expiredAt, now) {
+				continue
+			}
+			if len(keys) == count {
+				next = keys[count-1]
+				return nil
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return keys, next, nil
+}
+
+// Ping checks that the database is open and the buckets exist
+func (c *BoltStore) Ping(ctx context.Context) error {
+	return c.client.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(c.bucket) == nil {
+			return bolt.ErrBucketNotFound
+		}
+		return nil
+	})
+}
+
+// Close stops the sweeper and closes the file
+func (c *BoltStore) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		err = c.client.Close()
+	})
+	return err
+}
+
+// Codec returns the codec of values
+func (c *BoltStore) Codec() Codec {
+	return c.codec
+}
+
+func (c *BoltStore) Type() string {
+	return "bolt"
+}
+
+func (c *BoltStore) createBuckets(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists(c.bucket); err != nil {
+		return err
+	}
+	_, err := tx.CreateBucketIfNotExists(c.expiry)
+	return err
+}
+
+// read returns the value of key, which is only valid during tx, and its expiration
+func (c *BoltStore) read(tx *bolt.Tx, key string) ([]byte, int64, error) {
+	var stored = tx.Bucket(c.bucket).Get([]byte(key))
+	if stored == nil {
+		return nil, 0, ErrKeyNotFound
+	}
+
+	expiredAt, value, ok := decodeBoltValue(stored)
+	if !ok {
+		return nil, 0, ErrUnmarshal
+	}
+	if boltExpired(expiredAt, c.now().UnixNano()) {
+		return nil, 0, ErrKeyNotFound
+	}
+	return value, expiredAt, nil
+}
+
+// write replaces the entry of key and moves it in the expiration index
+func (c *BoltStore) write(tx *bolt.Tx, key string, value []byte, expiredAt int64) error {
+	if err := c.unindex(tx, key); err != nil {
+		return err
+	}
+
+	var stored = make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(stored, uint64(expiredAt))
+	copy(stored[8:], value)
+	if err := tx.Bucket(c.bucket).Put([]byte(key), stored); err != nil {
+		return err
+	}
+
+	if expiredAt == 0 {
+		return nil
+	}
+	return tx.Bucket(c.expiry).Put(boltExpiryKey(expiredAt, key), nil)
+}
+
+func (c *BoltStore) remove(tx *bolt.Tx, key string) error {
+	if err := c.unindex(tx, key); err != nil {
+		return err
+	}
+	return tx.Bucket(c.bucket).Delete([]byte(key))
+}
+
+// unindex removes the current entry of key from the expiration index
+func (c *BoltStore) unindex(tx *bolt.Tx, key string) error {
+	var stored = tx.Bucket(c.bucket).Get([]byte(key))
+	if stored == nil {
+		return nil
+	}
+
+	expiredAt, _, ok := decodeBoltValue(stored)
+	if !ok || expiredAt == 0 {
+		return nil
+	}
+	return tx.Bucket(c.expiry).Delete(boltExpiryKey(expiredAt, key))
+}
+
+// sweeper removes expired entries every interval until the store is closed
+func (c *BoltStore) sweeper(interval time.Duration) {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.log.swallowed("sweep", "", c.sweep())
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// sweep walks the expiration index up to now and removes the entries it points to
+func (c *BoltStore) sweep() error {
+	var now = c.now().UnixNano()
+	var expired []string
+
+	err := c.client.Update(func(tx *bolt.Tx) error {
+		var index = tx.Bucket(c.expiry)
+		var bucket = tx.Bucket(c.bucket)
+
+		var it = index.Cursor()
+		for k, _ := it.First(); k != nil && int64(binary.BigEndian.Uint64(k)) <= now; k, _ = it.First() {
+			var key = string(k[8:])
+			if err := index.Delete(k); err != nil {
+				return err
+			}
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range expired {
+		c.log.events.fireEvict(key)
+	}
+	return nil
+}
+
+func (c *BoltStore) expiredAt(expiration time.Duration) int64 {
+	if expiration <= 0 {
+		return 0
+	}
+	return c.now().Add(expiration).UnixNano()
+}
+
+// boltExpiryKey sorts the expiration index by time, then by key
+func boltExpiryKey(expiredAt int64, key string) []byte {
+	var k = make([]byte, 8+len(key))
+	binary.BigEndian.PutUint64(k, uint64(expiredAt))
+	copy(k[8:], key)
+	return k
+}
+
+func decodeBoltValue(stored []byte) (int64, []byte, bool) {
+	if len(stored) < 8 {
+		return 0, nil, false
+	}
+	return int64(binary.BigEndian.Uint64(stored)), stored[8:], true
+}
+
+func boltExpired(expiredAt int64, now int64) bool {
+	return expiredAt > 0 && expiredAt <= now
+}
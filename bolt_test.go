@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltStoreSweep(t *testing.T) {
+	var evicted []string
+	var store = NewBoltStore(&BoltStoreOptions{
+		Path:          filepath.Join(t.TempDir(), "cache.db"),
+		SweepInterval: -1,
+		Events: &Events{
+			OnEvict: func(key string) { evicted = append(evicted, key) },
+		},
+	})
+	defer store.Close()
+
+	var now = time.Now()
+	store.now = func() time.Time { return now }
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_sweep_expired", &strIn, time.Minute))
+	assert.NoError(t, store.Set("test_sweep_touched", &strIn, time.Minute))
+	assert.NoError(t, store.Set("test_sweep_overwritten", &strIn, time.Minute))
+	assert.NoError(t, store.Set("test_sweep_never", &strIn))
+
+	// Touch and overwrite move the entries in the expiration index
+	assert.NoError(t, store.Touch("test_sweep_touched", time.Hour))
+	assert.NoError(t, store.Set("test_sweep_overwritten", &strIn))
+
+	now = now.Add(2 * time.Minute)
+
+	// Expired entries are hidden before the sweep
+	found, err := store.Exists("test_sweep_expired")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, store.sweep())
+	assert.Equal(t, []string{"test_sweep_expired"}, evicted)
+
+	keys, _, err := store.Scan(context.Background(), "test_sweep_*", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test_sweep_never", "test_sweep_overwritten", "test_sweep_touched"}, keys)
+
+	ttl, err := store.TTL("test_sweep_touched")
+	assert.NoError(t, err)
+	assert.Equal(t, 58*time.Minute, ttl)
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "cache.db")
+	var store = NewBoltStore(&BoltStoreOptions{Path: path})
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_reopen", &strIn))
+	assert.NoError(t, store.Close())
+
+	store = NewBoltStore(&BoltStoreOptions{Path: path})
+	defer store.Close()
+
+	var strOut string
+	assert.NoError(t, store.Get("test_reopen", &strOut))
+	assert.Equal(t, strIn, strOut)
+
+	assert.NoError(t, store.Clear())
+	assert.Equal(t, ErrKeyNotFound, store.Get("test_reopen", &strOut))
+}
@@ -0,0 +1,362 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the store while a CircuitBreaker is open
+var ErrCircuitOpen = errors.New("cache: Circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets every call through and counts failures
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails every call fast with ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen lets a few probes through to decide whether to close again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type CircuitBreakerOptions struct {
+	// Window is the period failures are counted over, counts restart every window
+	Window time.Duration
+	// MinRequests is the number of calls in a window before the breaker may trip
+	MinRequests int
+	// FailureRate trips the breaker once the share of failed calls in a window reaches it
+	FailureRate float64
+	// SlowThreshold counts calls slower than it as failures, 0 disables it
+	SlowThreshold time.Duration
+	// OpenTimeout is how long the breaker fails fast before letting probes through
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of probes let through while half-open,
+	// the breaker closes once all of them succeed
+	HalfOpenRequests int
+
+	// Logger receives state changes, default is DefaultLogger
+	Logger Logger
+	// OnStateChange is called after every state change
+	OnStateChange func(from CircuitState, to CircuitState)
+}
+
+var CircuitBreakerOptionsDefault = &CircuitBreakerOptions{
+	Window:           10 * time.Second,
+	MinRequests:      20,
+	FailureRate:      0.5,
+	OpenTimeout:      5 * time.Second,
+	HalfOpenRequests: 1,
+}
+
+// CircuitBreaker stops calling a degraded store. Misses, failed write
+// conditions and codec errors are outcomes of the call, not store failures,
+// and never trip it. A Chain skips tiers whose breaker is open
+type CircuitBreaker struct {
+	Cache
+	options CircuitBreakerOptions
+	logger  Logger
+	now     func() time.Time
+
+	mu         sync.Mutex
+	state      CircuitState
+	generation uint64
+	windowEnd  time.Time
+	openedAt   time.Time
+	requests   int
+	failures   int
+	probes     int
+	successes  int
+}
+
+// NewCircuitBreaker panics on invalid options, use OpenCircuitBreaker to handle them
+func NewCircuitBreaker(cache Cache, options *CircuitBreakerOptions) *CircuitBreaker {
+	breaker, err := OpenCircuitBreaker(cache, options)
+	if err != nil {
+		panic(err)
+	}
+	return breaker
+}
+
+// OpenCircuitBreaker returns ErrInvalidOptions when FailureRate is above 1,
+// zero options are defaulted
+func OpenCircuitBreaker(cache Cache, options *CircuitBreakerOptions) (*CircuitBreaker, error) {
+	if options == nil {
+		options = CircuitBreakerOptionsDefault
+	}
+
+	var breaker = &CircuitBreaker{
+		Cache:   cache,
+		options: *options,
+		logger:  options.Logger,
+		now:     time.Now,
+	}
+	if breaker.options.Window <= 0 {
+		breaker.options.Window = CircuitBreakerOptionsDefault.Window
+	}
+	if breaker.options.MinRequests <= 0 {
+		breaker.options.MinRequests = CircuitBreakerOptionsDefault.MinRequests
+	}
+	if breaker.options.FailureRate <= 0 {
+		breaker.options.FailureRate = CircuitBreakerOptionsDefault.FailureRate
+	}
+	if breaker.options.FailureRate > 1 {
+		return nil, invalidOptions("circuit_breaker", "FailureRate must be at most 1, got %v", breaker.options.FailureRate)
+	}
+	if breaker.options.OpenTimeout <= 0 {
+		breaker.options.OpenTimeout = CircuitBreakerOptionsDefault.OpenTimeout
+	}
+	if breaker.options.HalfOpenRequests <= 0 {
+		breaker.options.HalfOpenRequests = CircuitBreakerOptionsDefault.HalfOpenRequests
+	}
+	if breaker.logger == nil {
+		breaker.logger = DefaultLogger
+	}
+
+	return breaker, nil
+}
+
+// Unwrap returns the wrapped cache
+func (b *CircuitBreaker) Unwrap() Cache {
+	return b.Cache
+}
+
+// State returns the current state, an open breaker past its timeout is half-open
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	var state, change = b.current(b.now())
+	b.mu.Unlock()
+
+	b.notify(change)
+	return state
+}
+
+func (b *CircuitBreaker) Get(key string, value interface{}) error {
+	return b.do(func() error {
+		return b.Cache.Get(key, value)
+	})
+}
+
+func (b *CircuitBreaker) Set(key string, value interface{}, expiration ...time.Duration) error {
+	return b.do(func() error {
+		return b.Cache.Set(key, value, expiration...)
+	})
+}
+
+func (b *CircuitBreaker) Delete(key string) error {
+	return b.do(func() error {
+		return b.Cache.Delete(key)
+	})
+}
+
+func (b *CircuitBreaker) GetBytes(key string) ([]byte, error) {
+	var bytes []byte
+	var err = b.do(func() (err error) {
+		bytes, err = b.Cache.GetBytes(key)
+		return err
+	})
+	return bytes, err
+}
+
+func (b *CircuitBreaker) SetBytes(key string, bytes []byte, expiration ...time.Duration) error {
+	return b.do(func() error {
+		return b.Cache.SetBytes(key, bytes, expiration...)
+	})
+}
+
+func (b *CircuitBreaker) Exists(key string) (bool, error) {
+	var found bool
+	var err = b.do(func() (err error) {
+		found, err = b.Cache.Exists(key)
+		return err
+	})
+	return found, err
+}
+
+func (b *CircuitBreaker) TTL(key string) (time.Duration, error) {
+	var ttl time.Duration
+	var err = b.do(func() (err error) {
+		ttl, err = b.Cache.TTL(key)
+		return err
+	})
+	return ttl, err
+}
+
+func (b *CircuitBreaker) Touch(key string, ttl time.Duration) error {
+	return b.do(func() error {
+		return b.Cache.Touch(key, ttl)
+	})
+}
+
+func (b *CircuitBreaker) Clear() error {
+	return b.do(func() error {
+		return b.Cache.Clear()
+	})
+}
+
+func (b *CircuitBreaker) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	var keys []string
+	var next string
+	var err = b.do(func() (err error) {
+		keys, next, err = b.Cache.Scan(ctx, pattern, cursor, count)
+		return err
+	})
+	return keys, next, err
+}
+
+// do calls fn unless the breaker rejects the call, and records its outcome
+func (b *CircuitBreaker) do(fn func() error) error {
+	generation, err := b.before()
+	if err != nil {
+		return err
+	}
+
+	var start = b.now()
+	err = fn()
+	b.after(generation, b.now().Sub(start), err)
+	return err
+}
+
+// open reports whether calls are rejected right now without taking a probe slot
+func (b *CircuitBreaker) open() bool {
+	return b.State() == CircuitOpen
+}
+
+// before admits a call and returns the generation its outcome belongs to
+func (b *CircuitBreaker) before() (uint64, error) {
+	b.mu.Lock()
+	var generation, change, err = b.admit()
+	b.mu.Unlock()
+
+	b.notify(change)
+	return generation, err
+}
+
+func (b *CircuitBreaker) admit() (uint64, *circuitChange, error) {
+	var state, change = b.current(b.now())
+
+	switch state {
+	case CircuitOpen:
+		return 0, change, ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.probes >= b.options.HalfOpenRequests {
+			return 0, change, ErrCircuitOpen
+		}
+		b.probes++
+	}
+	return b.generation, change, nil
+}
+
+// after records the outcome of a call admitted in generation
+func (b *CircuitBreaker) after(generation uint64, elapsed time.Duration, err error) {
+	b.mu.Lock()
+	var change = b.record(generation, elapsed, err)
+	b.mu.Unlock()
+
+	b.notify(change)
+}
+
+func (b *CircuitBreaker) record(generation uint64, elapsed time.Duration, err error) *circuitChange {
+	// The outcome of a call started before the last state change is stale
+	if generation != b.generation {
+		return nil
+	}
+
+	var failed = isStoreFailure(err) || (b.options.SlowThreshold > 0 && elapsed > b.options.SlowThreshold)
+	var change *circuitChange
+
+	switch b.state {
+	case CircuitClosed:
+		var now = b.now()
+		if now.After(b.windowEnd) {
+			b.requests, b.failures = 0, 0
+			b.windowEnd = now.Add(b.options.Window)
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.options.MinRequests && float64(b.failures) >= b.options.FailureRate*float64(b.requests) {
+			change = b.transition(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			change = b.transition(CircuitOpen, b.now())
+			break
+		}
+		b.successes++
+		if b.successes >= b.options.HalfOpenRequests {
+			change = b.transition(CircuitClosed, b.now())
+		}
+	}
+	return change
+}
+
+// current returns the state at now, moving an open breaker past its timeout to half-open
+func (b *CircuitBreaker) current(now time.Time) (CircuitState, *circuitChange) {
+	if b.state == CircuitOpen && !now.Before(b.openedAt.Add(b.options.OpenTimeout)) {
+		return CircuitHalfOpen, b.transition(CircuitHalfOpen, now)
+	}
+	return b.state, nil
+}
+
+type circuitChange struct {
+	from CircuitState
+	to   CircuitState
+}
+
+// transition moves to state and restarts the counts, it must be called with mu held
+func (b *CircuitBreaker) transition(state CircuitState, now time.Time) *circuitChange {
+	var change = &circuitChange{from: b.state, to: state}
+
+	b.state = state
+	b.generation++
+	b.requests, b.failures = 0, 0
+	b.probes, b.successes = 0, 0
+	b.windowEnd = now.Add(b.options.Window)
+	if state == CircuitOpen {
+		b.openedAt = now
+	}
+	return change
+}
+
+// notify reports a state change, it is called once mu is released so the
+// callback may use the breaker
+func (b *CircuitBreaker) notify(change *circuitChange) {
+	if change == nil {
+		return
+	}
+
+	b.logger.Warn("cache: circuit breaker state changed", "store", b.Cache.Type(), "from", change.from, "to", change.to)
+	if b.options.OnStateChange != nil {
+		b.options.OnStateChange(change.from, change.to)
+	}
+}
+
+// isStoreFailure reports whether err says something about the health of the
+// store rather than about the key or the value
+func isStoreFailure(err error) bool {
+	if err == nil || isExpected(err) {
+		return false
+	}
+
+	switch err {
+	case ErrMustBePointer, ErrMarshal, ErrUnmarshal, ErrNotCounter, ErrClearNotAllowed, ErrCircuitOpen, ErrKeyTooLong:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// failingStore fails every read with err while it is set
+type failingStore struct {
+	*MemoryStore
+	err   error
+	calls int
+}
+
+func (c *failingStore) GetBytes(key string) ([]byte, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.MemoryStore.GetBytes(key)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var now = time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	var store = &failingStore{MemoryStore: NewMemoryStore(MemoryStoreOptions{}), err: errUnavailable}
+	var changes []CircuitState
+	var breaker = NewCircuitBreaker(store, &CircuitBreakerOptions{
+		MinRequests: 4,
+		FailureRate: 0.5,
+		OpenTimeout: time.Second,
+		Logger:      NopLogger{},
+		OnStateChange: func(from CircuitState, to CircuitState) {
+			changes = append(changes, to)
+		},
+	})
+	breaker.now = func() time.Time { return now }
+
+	// Misses are not failures
+	for i := 0; i < 4; i++ {
+		_, err := breaker.GetBytes("test_breaker_missing")
+		assert.Equal(t, errUnavailable, err)
+	}
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	_, err := breaker.GetBytes("test_breaker")
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 4, store.calls)
+
+	// A failed probe opens the breaker again
+	now = now.Add(time.Second)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	_, err = breaker.GetBytes("test_breaker")
+	assert.Equal(t, errUnavailable, err)
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	now = now.Add(time.Second)
+	store.err = nil
+	_, err = breaker.GetBytes("test_breaker")
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, CircuitClosed, breaker.State())
+
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, changes)
+}
+
+func TestCircuitBreakerIgnoresMisses(t *testing.T) {
+	var breaker = NewCircuitBreaker(NewMemoryStore(MemoryStoreOptions{}), &CircuitBreakerOptions{
+		MinRequests: 1,
+		Logger:      NopLogger{},
+	})
+
+	var strOut string
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, ErrKeyNotFound, breaker.Get("test_breaker_missing", &strOut))
+	}
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+func TestChainSkipsOpenTier(t *testing.T) {
+	var l1 = &failingStore{MemoryStore: NewMemoryStore(MemoryStoreOptions{}), err: errUnavailable}
+	var l2 = NewMemoryStore(MemoryStoreOptions{})
+	var breaker = NewCircuitBreaker(l1, &CircuitBreakerOptions{
+		MinRequests: 1,
+		OpenTimeout: time.Hour,
+		Logger:      NopLogger{},
+	})
+	var chain = NewChain(breaker, l2).SetLogger(NopLogger{}, 0)
+
+	var strIn = "Hello world"
+	assert.NoError(t, chain.Set("test_breaker", &strIn))
+
+	var strOut string
+	assert.NoError(t, chain.Get("test_breaker", &strOut))
+	assert.Equal(t, strIn, strOut)
+	assert.Equal(t, CircuitOpen, breaker.State())
+	assert.Equal(t, 1, l1.calls)
+
+	// The open tier is neither read nor backfilled
+	assert.NoError(t, chain.Get("test_breaker", &strOut))
+	assert.Equal(t, 1, l1.calls)
+	assert.NoError(t, chain.Set("test_breaker", &strIn))
+	assert.Equal(t, ErrCircuitOpen, chain.Delete("test_breaker"))
+
+	// Wrappers in front of the breaker are looked through
+	chain = NewChain(NewInstrumented(breaker, NewMemoryMetrics(), "l1"), l2).SetLogger(NopLogger{}, 0)
+	assert.NoError(t, chain.Set("test_breaker", &strIn))
+	assert.NoError(t, chain.Get("test_breaker", &strOut))
+	assert.Equal(t, 1, l1.calls)
+}
+
+func TestCircuitBreakerOptions(t *testing.T) {
+	var breaker = NewCircuitBreaker(NewMemoryStore(MemoryStoreOptions{}), &CircuitBreakerOptions{FailureRate: 1})
+	assert.Equal(t, CircuitBreakerOptionsDefault.MinRequests, breaker.options.MinRequests)
+
+	assert.Panics(t, func() {
+		NewCircuitBreaker(NewMemoryStore(MemoryStoreOptions{}), &CircuitBreakerOptions{FailureRate: 1.5})
+	})
+
+	_, err := OpenCircuitBreaker(NewMemoryStore(MemoryStoreOptions{}), &CircuitBreakerOptions{FailureRate: 1.5})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	assert.EqualError(t, err, "cache: Invalid options: circuit_breaker: FailureRate must be at most 1, got 1.5")
+}
@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"context"
 	"errors"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -9,7 +11,8 @@ import (
 
 // Default
 const (
-	NoExpiration = time.Duration(0)
+	NoExpiration     = time.Duration(0)
+	DefaultScanCount = 100
 )
 
 // Errors
@@ -20,9 +23,17 @@ var (
 	ErrMustBePointer          = errors.New("cache: Must be a pointer")
 	ErrMemcacheServerRequired = errors.New("cache: Memcache must have a valid server")
 	ErrRistrettoWrite         = errors.New("cache: Ristretto write error")
+	ErrClearNotAllowed        = errors.New("cache: Clear is not allowed for this store")
+	ErrNotSupported           = errors.New("cache: Operation not supported by this store")
+	ErrNotCounter             = errors.New("cache: Value is not a counter")
+	ErrNotStored              = errors.New("cache: Value not stored, write condition not met")
+	ErrCASConflict            = errors.New("cache: Value changed since it was read")
+	ErrTimeout                = errors.New("cache: Operation timed out")
+	ErrKeyTooLong             = errors.New("cache: Key is too long for this store")
 )
 
-var DefaultLogger = log.New(os.Stdout, "", log.Ldate|log.Ltime)
+// DefaultLogger is used by stores, Chain and wrappers unless a Logger is configured
+var DefaultLogger Logger = NewStdLogger(log.New(os.Stdout, "", log.Ldate|log.Ltime))
 
 // Cache interface
 type Cache interface {
@@ -32,5 +43,82 @@ type Cache interface {
 
 	Delete(key string) error
 
+	// GetBytes returns the stored bytes of key without decoding them
+	GetBytes(key string) ([]byte, error)
+
+	// SetBytes stores bytes as is, without encoding them
+	SetBytes(key string, bytes []byte, expiration ...time.Duration) error
+
+	// Exists reports whether key is present without decoding its value
+	Exists(key string) (bool, error)
+
+	// TTL returns the remaining lifetime of key, NoExpiration when the key
+	// never expires, or ErrKeyNotFound
+	TTL(key string) (time.Duration, error)
+
+	// Touch resets the lifetime of key to ttl without rewriting its value,
+	// a ttl of NoExpiration makes the key persistent
+	Touch(key string, ttl time.Duration) error
+
+	// Clear removes every key in the store's namespace
+	Clear() error
+
+	// Scan returns a page of at most count keys matching the glob pattern,
+	// starting at cursor. Pass an empty cursor to start, an empty next cursor
+	// means the walk is done. Stores that cannot list keys return ErrNotSupported
+	Scan(ctx context.Context, pattern string, cursor string, count int) (keys []string, next string, err error)
+
+	// Ping checks that the store is reachable, for health checks
+	Ping(ctx context.Context) error
+
+	// Close releases the connections and goroutines of the store,
+	// it must not be used afterwards
+	io.Closer
+
 	Type() string
 }
+
+// Counter is implemented by stores supporting atomic increments. A missing key
+// starts at zero and gets the expiration, or the store default, only when it is
+// created. Get reads counters like values set with an int64, GetBytes returns
+// them as stored, Redis and Memcached keep them as decimal strings.
+// Incrementing a value that is not a counter returns ErrNotCounter.
+// Memcached counters are unsigned, decrementing them stops at zero
+type Counter interface {
+	Increment(key string, delta int64, expiration ...time.Duration) (int64, error)
+
+	Decrement(key string, delta int64, expiration ...time.Duration) (int64, error)
+}
+
+// CASToken identifies the version of a value read with GetCAS
+type CASToken struct {
+	value interface{}
+}
+
+// version returns what the store put in the token, nil for a nil token
+func (t *CASToken) version() interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.value
+}
+
+// ConditionalWriter is implemented by stores supporting conditional writes
+type ConditionalWriter interface {
+	// Add sets value only if key is absent, otherwise it returns ErrNotStored
+	Add(key string, value interface{}, expiration ...time.Duration) error
+
+	// Replace sets value only if key is present, otherwise it returns ErrNotStored
+	Replace(key string, value interface{}, expiration ...time.Duration) error
+
+	// GetCAS works like Get and returns a token for CompareAndSwap
+	GetCAS(key string, value interface{}) (*CASToken, error)
+
+	// CompareAndSwap sets value only if key was not written since token was
+	// read, otherwise it returns ErrCASConflict
+	CompareAndSwap(key string, value interface{}, token *CASToken, expiration ...time.Duration) error
+
+	// CompareAndDelete deletes key only if it was not written since token was
+	// read, otherwise it returns ErrCASConflict
+	CompareAndDelete(key string, token *CASToken) error
+}
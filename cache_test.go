@@ -1,6 +1,9 @@
 package cache
 
 import (
+	"context"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -71,6 +74,9 @@ func testStore(t *testing.T) {
 
 	assert.Equal(t, strOut, strIn)
 
+	var intOut int
+	assert.Equal(t, ErrUnmarshal, instance.Get(key, &intOut))
+
 	// Test struct
 	var itemIn = AutoGenerated{
 		ID:          "jt_standard_small_c0cbv999vbk2btpr6b702222222222",
@@ -129,19 +135,245 @@ func testStore(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.Equal(t, boolIn, boolOut)
+
+	// Test exists and ttl
+	found, err := instance.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	err = instance.Set(key, &boolIn, time.Hour)
+	assert.NoError(t, err)
+
+	ttl, err := instance.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Hour-time.Minute && ttl <= time.Hour)
+
+	// TTL follows Touch
+	assert.NoError(t, instance.Touch(key, 2*time.Hour))
+	ttl, err = instance.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > 2*time.Hour-time.Minute && ttl <= 2*time.Hour)
+
+	err = instance.Delete(key)
+	assert.NoError(t, err)
+
+	found, err = instance.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = instance.TTL(key)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// Test raw bytes are stored as is, even when they read as a counter
+	assert.NoError(t, instance.SetBytes(key, []byte("42")))
+	rawOut, err := instance.GetBytes(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("42"), rawOut)
+	assert.NoError(t, instance.Delete(key))
+
+	// Test counters read like int64 values and values are not counters
+	if counter, ok := instance.(Counter); ok {
+		var intIn = 53
+		assert.NoError(t, instance.Set(key, &intIn))
+		var intOut int
+		assert.NoError(t, instance.Get(key, &intOut))
+		assert.Equal(t, intIn, intOut)
+		_, err = counter.Increment(key, 1)
+		assert.Equal(t, ErrNotCounter, err)
+
+		assert.NoError(t, instance.Delete(key))
+		value, err := counter.Increment(key, 5)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), value)
+		var counterOut int64
+		assert.NoError(t, instance.Get(key, &counterOut))
+		assert.Equal(t, int64(5), counterOut)
+		assert.NoError(t, instance.Delete(key))
+	}
+
+	// Test a token is stale after the value changed and was written back
+	if writer, ok := instance.(ConditionalWriter); ok {
+		assert.NoError(t, instance.Set(key, &strIn))
+		token, err := writer.GetCAS(key, &strOut)
+		assert.NoError(t, err)
+
+		// A failed Add is not a write
+		assert.Equal(t, ErrNotStored, writer.Add(key, &strIn))
+		var strNew = "Goodbye"
+		assert.NoError(t, writer.CompareAndSwap(key, &strNew, token))
+
+		token, err = writer.GetCAS(key, &strOut)
+		assert.NoError(t, err)
+		assert.NoError(t, instance.Set(key, &strIn))
+		assert.NoError(t, instance.Set(key, &strNew))
+		assert.Equal(t, ErrCASConflict, writer.CompareAndSwap(key, &strIn, token))
+		assert.Equal(t, ErrCASConflict, writer.CompareAndDelete(key, token))
+
+		assert.Equal(t, ErrCASConflict, writer.CompareAndSwap(key, &strIn, nil))
+		assert.Equal(t, ErrCASConflict, writer.CompareAndDelete(key, nil))
+		assert.NoError(t, instance.Delete(key))
+	}
+
+	assert.NoError(t, instance.Ping(context.Background()))
+}
+
+func TestMemoryCacheClear(t *testing.T) {
+	var store = NewMemoryStore(MemoryStoreOptions{})
+	var chain Cache = NewChain(store, NewMemoryStore(MemoryStoreOptions{}))
+
+	var strIn = "Hello world"
+	assert.NoError(t, chain.Set("test_clear_1", &strIn))
+	assert.NoError(t, chain.Set("test_clear_2", &strIn))
+
+	assert.NoError(t, chain.Clear())
+
+	found, err := chain.Exists("test_clear_1")
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, 0, store.client.ItemCount())
 }
+
+func TestMemoryStoreClose(t *testing.T) {
+	var evicted = make(chan string, 1)
+	var store = NewMemoryStore(MemoryStoreOptions{
+		CleanupInterval: 10 * time.Millisecond,
+		Events: &Events{
+			OnEvict: func(key string) { evicted <- key },
+		},
+	})
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_close", &strIn, time.Millisecond))
+	assert.Equal(t, "test_close", <-evicted)
+
+	// The janitor is stopped, expired items are left until they are read
+	assert.NoError(t, store.Close())
+	assert.NoError(t, store.Close())
+	assert.NoError(t, store.Set("test_close", &strIn, time.Millisecond))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, store.client.ItemCount())
+}
+
+func TestMemoryCounter(t *testing.T) {
+	var counter Counter = NewMemoryStore(MemoryStoreOptions{})
+	var key = "test_counter"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counter.Increment(key, 2, time.Hour)
+		}()
+	}
+	wg.Wait()
+
+	value, err := counter.Decrement(key, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(99), value)
+
+	var out int64
+	assert.NoError(t, counter.(Cache).Get(key, &out))
+	assert.Equal(t, int64(99), out)
+
+	ttl, err := counter.(Cache).TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Hour-time.Minute)
+
+	// GetCAS reads counters like Get
+	out = 0
+	_, err = counter.(ConditionalWriter).GetCAS(key, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(99), out)
+	var strOut string
+	_, err = counter.(ConditionalWriter).GetCAS(key, &strOut)
+	assert.Equal(t, ErrUnmarshal, err)
+
+	// Missing keys start at zero
+	value, err = counter.Decrement("test_counter_missing", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-3), value)
+
+	var strIn = "Hello world"
+	assert.NoError(t, counter.(Cache).Set("test_not_counter", &strIn))
+	_, err = counter.Increment("test_not_counter", 1)
+	assert.Equal(t, ErrNotCounter, err)
+}
+
+func TestMemoryConditionalWrites(t *testing.T) {
+	var store ConditionalWriter = NewMemoryStore(MemoryStoreOptions{})
+	var key = "test_conditional"
+
+	var strIn = "Hello world"
+	assert.Equal(t, ErrNotStored, store.Replace(key, &strIn))
+	assert.NoError(t, store.Add(key, &strIn))
+	assert.Equal(t, ErrNotStored, store.Add(key, &strIn))
+
+	var strNew = "Goodbye"
+	assert.NoError(t, store.Replace(key, &strNew))
+
+	var strOut string
+	token, err := store.GetCAS(key, &strOut)
+	assert.NoError(t, err)
+	assert.Equal(t, strNew, strOut)
+
+	var strCAS = "Hello again"
+	assert.NoError(t, store.CompareAndSwap(key, &strCAS, token))
+
+	// The token is stale once the value changed
+	assert.Equal(t, ErrCASConflict, store.CompareAndSwap(key, &strIn, token))
+
+	assert.NoError(t, store.(Cache).Get(key, &strOut))
+	assert.Equal(t, strCAS, strOut)
+}
+
 func TestRedisCache(t *testing.T) {
 	instance = NewRedisStore(&RedisStoreOptions{
 		Address: "localhost:6379",
 	})
+	defer instance.Close()
 	testStore(t)
 
 }
 
 func TestMemoryCache(t *testing.T) {
 	instance = NewMemoryStore(MemoryStoreOptions{})
+	defer instance.Close()
+	testStore(t)
+
+}
+
+func TestDiskCache(t *testing.T) {
+	instance = NewDiskStore(DiskStoreOptions{Dir: t.TempDir()})
+	defer instance.Close()
+	testStore(t)
+}
+
+func TestBadgerCache(t *testing.T) {
+	instance = NewBadgerStore(&BadgerStoreOptions{Dir: t.TempDir()})
+	defer instance.Close()
+	testStore(t)
+}
+
+func TestSQLCache(t *testing.T) {
+	instance = NewSQLStore(SQLStoreOptions{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "cache.db"),
+	})
+	defer instance.Close()
 	testStore(t)
+}
 
+func TestEtcdCache(t *testing.T) {
+	instance = NewEtcdStore(EtcdStoreOptions{Endpoints: []string{startEtcd(t)}})
+	defer instance.Close()
+	testStore(t)
+}
+
+func TestBoltCache(t *testing.T) {
+	instance = NewBoltStore(&BoltStoreOptions{Path: filepath.Join(t.TempDir(), "cache.db")})
+	defer instance.Close()
+	testStore(t)
 }
 
 func TestMemcacheCache(t *testing.T) {
@@ -154,6 +386,7 @@ func TestMemcacheCache(t *testing.T) {
 	instance = NewMemcacheStore(&MemcacheStoreOptions{
 		Servers: []string{"localhost:11211"},
 	})
+	defer instance.Close()
 
 	testStore(t)
 
@@ -165,6 +398,7 @@ func TestMongoDBCache(t *testing.T) {
 		DatabaseName: "test_cache",
 		Entity:       "caches",
 	})
+	defer instance.Close()
 	testStore(t)
 
 }
@@ -1,67 +1,331 @@
 package cache
 
 import (
+	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Chain layers caches, reads go to the first tier holding the key and writes
+// to every tier. Tiers behind an open CircuitBreaker, possibly under wrappers
+// with an Unwrap method, are skipped by reads, Set, SetBytes and Touch.
+// Delete and Clear still return ErrCircuitOpen, so a missed invalidation is
+// never silent
 type Chain struct {
 	caches []Cache
+	log    storeLogger
+	codec  Codec
 }
 
+// NewChain decodes values with the codec of the first tier, tiers must share it
 func NewChain(caches ...Cache) *Chain {
 	var chain = &Chain{
 		caches: caches,
+		log:    newStoreLogger("chain", nil, 0, nil),
+		codec:  DefaultCodec,
+	}
+	if len(caches) > 0 {
+		chain.codec = codecOf(caches[0])
 	}
 
 	return chain
 }
 
+// SetCodec sets the codec used by Get to decode the bytes read from the tiers
+func (c *Chain) SetCodec(codec Codec) *Chain {
+	c.codec = codec
+	return c
+}
+
+// Codec returns the codec of values
+func (c *Chain) Codec() Codec {
+	return c.codec
+}
+
+// SetLogger sets the logger receiving swallowed tier failures and operations
+// slower than slowThreshold, failures returned by a tier are logged by the tier
+func (c *Chain) SetLogger(logger Logger, slowThreshold time.Duration) *Chain {
+	c.log = newStoreLogger("chain", logger, slowThreshold, nil)
+	return c
+}
+
+// Get value by give key from the first tier holding it, tiers before it are
+// backfilled with the raw bytes so the value is decoded once and never re-encoded
 func (c *Chain) Get(key string, value interface{}) error {
-	for _, cache := range c.caches {
-		var err = cache.Get(key, value)
-		if err == nil {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, tier, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return decodeValue(c.codec, tier, bytes, value)
+}
+
+// GetBytes returns the stored bytes from the first tier holding key and
+// backfills the tiers before it with the remaining lifetime of the entry
+func (c *Chain) GetBytes(key string) ([]byte, error) {
+	bytes, _, err := c.get(key)
+	return bytes, err
+}
+
+// get returns the bytes of key and the tier holding them
+func (c *Chain) get(key string) ([]byte, Cache, error) {
+	defer c.log.slow("get", key, time.Now())
+
+	for i, cache := range c.caches {
+		if skipped(cache) {
+			continue
+		}
+
+		bytes, err := cache.GetBytes(key)
+		if err != nil {
+			c.log.swallowed("get:"+cache.Type(), key, err)
+			continue
+		}
+
+		if i > 0 {
+			c.backfill(key, bytes, cache, c.caches[:i])
+		}
+		return bytes, cache, nil
+	}
+
+	return nil, nil, ErrKeyNotFound
+}
+
+// SetBytes stores bytes in every tier, returns the first error reported by a tier
+func (c *Chain) SetBytes(key string, bytes []byte, expiration ...time.Duration) error {
+	defer c.log.slow("set", key, time.Now())
+
+	return c.each(func(cache Cache) error {
+		if skipped(cache) {
 			return nil
 		}
+		return cache.SetBytes(key, bytes, expiration...)
+	})
+}
+
+func (c *Chain) backfill(key string, bytes []byte, source Cache, tiers []Cache) {
+	var expiration []time.Duration
+	if ttl, err := source.TTL(key); err == nil && ttl > 0 {
+		expiration = append(expiration, ttl)
 	}
 
-	return ErrKeyNotFound
+	for _, cache := range tiers {
+		if skipped(cache) {
+			continue
+		}
+		c.log.swallowed("backfill:"+cache.Type(), key, cache.SetBytes(key, copyValue(c.codec, bytes, source, cache), expiration...))
+	}
 }
 
-// Set value by give key
+// Set value by give key, returns the first error reported by a tier
 func (c *Chain) Set(key string, value interface{}, expiration ...time.Duration) error {
-	var wg sync.WaitGroup
+	defer c.log.slow("set", key, time.Now())
+
+	return c.each(func(cache Cache) error {
+		if skipped(cache) {
+			return nil
+		}
+		return cache.Set(key, value, expiration...)
+	})
+}
+
+// Delete by give key, returns the first error reported by a tier
+func (c *Chain) Delete(key string) error {
+	defer c.log.slow("delete", key, time.Now())
+
+	return c.each(func(cache Cache) error {
+		return cache.Delete(key)
+	})
+}
 
+// Exists reports whether any tier holds key
+func (c *Chain) Exists(key string) (bool, error) {
+	var lastErr error
 	for _, cache := range c.caches {
-		wg.Add(1)
-		go func(wg *sync.WaitGroup, cache Cache) {
-			defer wg.Done()
+		if skipped(cache) {
+			continue
+		}
 
-			cache.Set(key, value, expiration...)
-		}(&wg, cache)
+		found, err := cache.Exists(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if found {
+			return true, nil
+		}
 	}
-	wg.Wait()
 
+	return false, lastErr
+}
+
+// TTL returns the remaining lifetime of key in the first tier holding it
+func (c *Chain) TTL(key string) (time.Duration, error) {
+	for _, cache := range c.caches {
+		if skipped(cache) {
+			continue
+		}
+
+		ttl, err := cache.TTL(key)
+		if err == nil {
+			return ttl, nil
+		}
+	}
+
+	return 0, ErrKeyNotFound
+}
+
+// Touch resets the lifetime of key in every tier holding it
+func (c *Chain) Touch(key string, ttl time.Duration) error {
+	var misses int32
+	var err = c.each(func(cache Cache) error {
+		if skipped(cache) {
+			atomic.AddInt32(&misses, 1)
+			return nil
+		}
+
+		var err = cache.Touch(key, ttl)
+		if err == ErrKeyNotFound {
+			atomic.AddInt32(&misses, 1)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if int(misses) == len(c.caches) {
+		return ErrKeyNotFound
+	}
 	return nil
 }
 
-// Delete by give key
-func (c *Chain) Delete(key string) error {
+// Clear every tier, returns the first error reported by a tier
+func (c *Chain) Clear() error {
+	return c.each(func(cache Cache) error {
+		return cache.Clear()
+	})
+}
+
+// Scan walks the keys of the last tier able to list them, upper tiers only
+// hold a subset of it
+func (c *Chain) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	for i := len(c.caches) - 1; i >= 0; i-- {
+		keys, next, err := c.caches[i].Scan(ctx, pattern, cursor, count)
+		if err == ErrNotSupported || err == ErrCircuitOpen {
+			continue
+		}
+		return keys, next, err
+	}
+
+	return nil, "", ErrNotSupported
+}
+
+// TierHealth is the outcome of pinging a tier
+type TierHealth struct {
+	// Store is the Type of the tier
+	Store   string
+	Latency time.Duration
+	// Err is nil when the tier is healthy
+	Err error
+}
+
+func (h TierHealth) Healthy() bool {
+	return h.Err == nil
+}
+
+// Health pings every tier concurrently, the result is in tier order
+func (c *Chain) Health(ctx context.Context) []TierHealth {
 	var wg sync.WaitGroup
+	var health = make([]TierHealth, len(c.caches))
 
-	for _, cache := range c.caches {
+	for i, cache := range c.caches {
 		wg.Add(1)
-		go func(wg *sync.WaitGroup, cache Cache) {
+		go func(wg *sync.WaitGroup, tier *TierHealth, cache Cache) {
 			defer wg.Done()
 
-			cache.Delete(key)
-		}(&wg, cache)
+			var start = time.Now()
+			tier.Store = cache.Type()
+			tier.Err = cache.Ping(ctx)
+			tier.Latency = time.Since(start)
+		}(&wg, &health[i], cache)
 	}
 	wg.Wait()
 
+	return health
+}
+
+// Ping fails with the first unhealthy tier. Reads keep being served while
+// a tier is down, use Health to decide per tier
+func (c *Chain) Ping(ctx context.Context) error {
+	for i, tier := range c.Health(ctx) {
+		if !tier.Healthy() {
+			return fmt.Errorf("cache: tier %d (%s) is unhealthy: %w", i, tier.Store, tier.Err)
+		}
+	}
 	return nil
 }
 
+// Close closes every tier, returns the first error reported by a tier
+func (c *Chain) Close() error {
+	return c.each(func(cache Cache) error {
+		return cache.Close()
+	})
+}
+
 func (c *Chain) Type() string {
 	return "chain"
 }
+
+// skipped reports whether cache is behind an open CircuitBreaker, wrappers
+// are looked through with their Unwrap method
+func skipped(cache Cache) bool {
+	for {
+		if breaker, ok := cache.(*CircuitBreaker); ok && breaker.open() {
+			return true
+		}
+
+		wrapper, ok := cache.(interface{ Unwrap() Cache })
+		if !ok {
+			return false
+		}
+		cache = wrapper.Unwrap()
+	}
+}
+
+// withCaches returns a copy of the chain running on caches instead of its tiers
+func (c *Chain) withCaches(caches []Cache) *Chain {
+	var chain = *c
+	chain.caches = caches
+	return &chain
+}
+
+// each runs fn against every tier concurrently and returns the first error in tier order
+func (c *Chain) each(fn func(cache Cache) error) error {
+	var wg sync.WaitGroup
+	var errs = make([]error, len(c.caches))
+
+	for i, cache := range c.caches {
+		wg.Add(1)
+		go func(wg *sync.WaitGroup, i int, cache Cache) {
+			defer wg.Done()
+
+			errs[i] = fn(cache)
+		}(&wg, i, cache)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
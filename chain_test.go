@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -98,3 +99,95 @@ func TestChain(t *testing.T) {
 
 	assert.Equal(t, boolIn, boolOut)
 }
+
+func TestChainBackfill(t *testing.T) {
+	var l1 = NewMemoryStore(MemoryStoreOptions{})
+	var l2 = NewMemoryStore(MemoryStoreOptions{})
+	var chain Cache = NewChain(l1, l2)
+
+	var key = "test_backfill"
+	var strIn = "Hello world"
+	assert.NoError(t, l2.Set(key, &strIn, time.Hour))
+
+	var strOut string
+	assert.NoError(t, chain.Get(key, &strOut))
+	assert.Equal(t, strIn, strOut)
+
+	// The first tier now holds the same bytes with the remaining lifetime
+	expected, err := l2.GetBytes(key)
+	assert.NoError(t, err)
+	bytes, err := l1.GetBytes(key)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, bytes)
+
+	ttl, err := l1.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Hour-time.Minute && ttl <= time.Hour)
+
+	// Raw bytes bypass the codec
+	assert.NoError(t, chain.SetBytes("test_raw", []byte("<html></html>")))
+	bytes, err = chain.GetBytes("test_raw")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("<html></html>"), bytes)
+}
+
+// decimalStore keeps counters as decimal strings, like Redis and Memcached
+type decimalStore struct {
+	*MemoryStore
+}
+
+func (c *decimalStore) decimalCounters() {}
+
+func TestChainDecimalCounters(t *testing.T) {
+	var l1 = NewMemoryStore(MemoryStoreOptions{})
+	var l2 = &decimalStore{NewMemoryStore(MemoryStoreOptions{})}
+	var chain Cache = NewChain(l1, l2)
+
+	// A counter of the second tier reads like an int64 and keeps its value
+	// once backfilled to a tier that does not keep decimal strings
+	assert.NoError(t, l2.SetBytes("test_counter", []byte("5")))
+	var counterOut int64
+	assert.NoError(t, chain.Get("test_counter", &counterOut))
+	assert.Equal(t, int64(5), counterOut)
+	counterOut = 0
+	assert.NoError(t, l1.Get("test_counter", &counterOut))
+	assert.Equal(t, int64(5), counterOut)
+
+	// A value encoded like a decimal string does not read as a counter
+	var intIn, intOut = 53, 0
+	assert.NoError(t, NewRetrying(l2, nil).Set("test_int", &intIn))
+	assert.NoError(t, chain.Get("test_int", &intOut))
+	assert.Equal(t, intIn, intOut)
+	bytes, err := l2.GetBytes("test_int")
+	assert.NoError(t, err)
+	assert.NotEqual(t, []byte("5"), bytes)
+}
+
+// unreachableStore fails every ping
+type unreachableStore struct {
+	*MemoryStore
+}
+
+func (c *unreachableStore) Ping(ctx context.Context) error {
+	return errUnavailable
+}
+
+func (c *unreachableStore) Type() string {
+	return "unreachable"
+}
+
+func TestChainHealth(t *testing.T) {
+	var chain = NewChain(NewMemoryStore(MemoryStoreOptions{}), &unreachableStore{NewMemoryStore(MemoryStoreOptions{})})
+	defer chain.Close()
+
+	var health = chain.Health(context.Background())
+	assert.Len(t, health, 2)
+	assert.Equal(t, "memory", health[0].Store)
+	assert.True(t, health[0].Healthy())
+	assert.Equal(t, "unreachable", health[1].Store)
+	assert.Equal(t, errUnavailable, health[1].Err)
+
+	var err = chain.Ping(context.Background())
+	assert.ErrorIs(t, err, errUnavailable)
+	assert.EqualError(t, err, "cache: tier 1 (unreachable) is unhealthy: unavailable")
+}
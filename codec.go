@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes the values passed to Set and decodes them in Get. Every
+// store and Chain sharing keys must use the same Codec
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+
+	Unmarshal(data []byte, value interface{}) error
+}
+
+// MsgpackCodec encodes values with MessagePack, it is the default
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(value interface{}) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, value interface{}) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+// JSONCodec encodes values with encoding/json, for keys shared with other languages
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+// DefaultCodec is used by stores and Chain unless a Codec is configured
+var DefaultCodec Codec = MsgpackCodec{}
+
+// codecOf returns the Codec of cache, wrappers decoding on behalf of a cache use it
+func codecOf(cache Cache) Codec {
+	if c, ok := cache.(interface{ Codec() Codec }); ok {
+		return c.Codec()
+	}
+	return DefaultCodec
+}
+
+// parseCounter reads a counter stored as a decimal string by Redis or
+// Memcached, which pads a counter that got shorter with spaces
+func parseCounter(bytes []byte) (int64, bool) {
+	if len(bytes) == 0 || len(bytes) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimRight(string(bytes), " "), 10, 64)
+	return n, err == nil
+}
+
+// decodeCounter encodes a counter stored as a decimal string with codec, like
+// the values written by Set, other bytes are returned as is
+func decodeCounter(codec Codec, bytes []byte) []byte {
+	n, ok := parseCounter(bytes)
+	if !ok {
+		return bytes
+	}
+	if encoded, err := codec.Marshal(n); err == nil {
+		return encoded
+	}
+	return bytes
+}
+
+// encodeNonCounter keeps encoded values from reading as a counter.
+// MessagePack writes the integers 48 to 57 as a single digit, they are
+// written as int64 instead
+func encodeNonCounter(codec Codec, bytes []byte) []byte {
+	n, ok := parseCounter(bytes)
+	if !ok {
+		return bytes
+	}
+
+	var value int64
+	if err := codec.Unmarshal(bytes, &value); err != nil || value == n {
+		return bytes
+	}
+	if encoded, err := codec.Marshal(value); err == nil {
+		return encoded
+	}
+	return bytes
+}
+
+// decimalCounterStore is implemented by the stores keeping counters as decimal
+// strings, Redis and Memcached. Their Get reads a decimal string like an
+// int64 and their Set encodes the values that would read as one again
+type decimalCounterStore interface {
+	decimalCounters()
+}
+
+// keepsDecimalCounters reports whether cache, or the store it wraps, keeps
+// counters as decimal strings
+func keepsDecimalCounters(cache Cache) bool {
+	for {
+		if _, ok := cache.(decimalCounterStore); ok {
+			return true
+		}
+		wrapper, ok := cache.(interface{ Unwrap() Cache })
+		if !ok {
+			return false
+		}
+		cache = wrapper.Unwrap()
+	}
+}
+
+// encodeValue encodes value for SetBytes of cache the way its Set does
+func encodeValue(codec Codec, cache Cache, value interface{}) ([]byte, error) {
+	bytes, err := codec.Marshal(value)
+	if err != nil {
+		return nil, ErrMarshal
+	}
+	if keepsDecimalCounters(cache) {
+		bytes = encodeNonCounter(codec, bytes)
+	}
+	return bytes, nil
+}
+
+// decodeValue decodes bytes returned by GetBytes of cache the way its Get does
+func decodeValue(codec Codec, cache Cache, bytes []byte, value interface{}) error {
+	if keepsDecimalCounters(cache) {
+		bytes = decodeCounter(codec, bytes)
+	}
+	if err := codec.Unmarshal(bytes, value); err != nil {
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+// copyValue converts bytes read from source to be written to target, so a
+// counter and the values that would read as one keep their meaning
+func copyValue(codec Codec, bytes []byte, source Cache, target Cache) []byte {
+	switch from, to := keepsDecimalCounters(source), keepsDecimalCounters(target); {
+	case from && !to:
+		return decodeCounter(codec, bytes)
+	case !from && to:
+		return encodeNonCounter(codec, bytes)
+	}
+	return bytes
+}
+
+// getValue reads a single store through GetBytes, so wrappers can measure the
+// payload, and calls Get of a Chain or another wrapper. It returns the size of
+// the payload read, -1 when it is unknown or nothing was read
+func getValue(cache Cache, key string, value interface{}) (int, error) {
+	if !isStore(cache) || !isPtr(value) {
+		return -1, cache.Get(key, value)
+	}
+
+	bytes, err := cache.GetBytes(key)
+	if err != nil {
+		return -1, err
+	}
+	if err := decodeValue(codecOf(cache), cache, bytes, value); err != nil {
+		return -1, err
+	}
+	return len(bytes), nil
+}
+
+// setValue is the write counterpart of getValue, it returns the size of the
+// payload written
+func setValue(cache Cache, key string, value interface{}, expiration ...time.Duration) (int, error) {
+	if !isStore(cache) || !isPtr(value) {
+		return -1, cache.Set(key, value, expiration...)
+	}
+
+	bytes, err := encodeValue(codecOf(cache), cache, value)
+	if err != nil {
+		return -1, err
+	}
+	if err := cache.SetBytes(key, bytes, expiration...); err != nil {
+		return -1, err
+	}
+	return len(bytes), nil
+}
@@ -0,0 +1,298 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a store or a Chain, it can be written in YAML or JSON:
+//
+//	type: chain
+//	tiers:
+//	  - type: memory
+//	    default_expiration: 1m
+//	  - type: redis
+//	    address: localhost:6379
+//	    prefix: "app:"
+//	    timeouts: {read: 100ms, write: 200ms}
+//
+// A tier can also be the name of a store, tiers: [memory, ristretto] uses their
+// default options. Keys other than type, codec and tiers are the Options of the store
+type Config struct {
+	// Type is the name a store is registered with, which matches its Type(), or "chain"
+	Type string
+	// Codec is the name of a registered codec, default is DefaultCodec
+	Codec string
+	// Tiers are the stores of a chain, in order
+	Tiers []Config
+	// Options are decoded into the options struct of the store by DecodeOptions
+	Options map[string]interface{}
+}
+
+// ParseConfig parses a YAML or JSON document
+func ParseConfig(data []byte) (*Config, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) UnmarshalYAML(node *yaml.Node) error {
+	var m map[string]interface{}
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+	return c.fromMap(m)
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	return c.fromMap(m)
+}
+
+func (c *Config) fromMap(m map[string]interface{}) error {
+	*c = Config{Options: make(map[string]interface{})}
+
+	for key, value := range m {
+		switch normalizeOption(key) {
+		case "type":
+			c.Type = fmt.Sprint(value)
+		case "codec":
+			c.Codec = fmt.Sprint(value)
+		case "tiers":
+			tiers, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("%w: tiers must be a list", ErrInvalidOptions)
+			}
+			for i, tier := range tiers {
+				var config Config
+				switch tier := tier.(type) {
+				case string:
+					// Shorthand for a tier with default options
+					if err := config.fromMap(map[string]interface{}{"type": tier}); err != nil {
+						return err
+					}
+				case map[string]interface{}:
+					if err := config.fromMap(tier); err != nil {
+						return err
+					}
+				default:
+					return fmt.Errorf("%w: tier %d must be a name or a mapping", ErrInvalidOptions, i)
+				}
+				c.Tiers = append(c.Tiers, config)
+			}
+		default:
+			c.Options[key] = value
+		}
+	}
+
+	if c.Type == "" {
+		return fmt.Errorf("%w: type is required", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// ConfigFromEnv reads a Config from the environment variables starting with prefix:
+//
+//	CACHE_TYPE=chain
+//	CACHE_TIERS=memory,redis
+//	CACHE_MEMORY_DEFAULT_EXPIRATION=1m
+//	CACHE_REDIS_ADDRESS=localhost:6379
+//
+// Every tier reads the variables of prefix_NAME, its type defaults to its name.
+// Options set from the environment are strings, nested options such as
+// Timeouts cannot be set
+func ConfigFromEnv(prefix string) (*Config, error) {
+	return configFromEnv(prefix, "")
+}
+
+func configFromEnv(prefix string, defaultType string) (*Config, error) {
+	prefix = strings.ToUpper(prefix) + "_"
+
+	var config = Config{Type: defaultType, Options: make(map[string]interface{})}
+	var tiers string
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		switch name := strings.ToLower(strings.TrimPrefix(key, prefix)); name {
+		case "type":
+			config.Type = value
+		case "codec":
+			config.Codec = value
+		case "tiers":
+			tiers = value
+		default:
+			config.Options[name] = value
+		}
+	}
+
+	if config.Type == "" {
+		return nil, fmt.Errorf("%w: %sTYPE is required", ErrInvalidOptions, prefix)
+	}
+	if config.Type != "chain" {
+		return &config, nil
+	}
+
+	// The variables of the tiers share the prefix of the chain
+	config.Options = nil
+	for _, name := range strings.Split(tiers, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		tier, err := configFromEnv(prefix+name, strings.ToLower(name))
+		if err != nil {
+			return nil, err
+		}
+		config.Tiers = append(config.Tiers, *tier)
+	}
+	return &config, nil
+}
+
+// DecodeOptions sets the fields of the struct pointed to by target from options.
+// Keys match field names ignoring case, "_" and "-". Durations are strings
+// such as "1m30s" or numbers of seconds, lists are sequences or comma separated
+// strings and nested structs are mappings. Unknown keys are errors
+func DecodeOptions(options map[string]interface{}, target interface{}) error {
+	var rv = reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cache: DecodeOptions target must be a pointer to a struct, got %T", target)
+	}
+	rv = rv.Elem()
+
+	var fields = make(map[string]int)
+	for i := 0; i < rv.NumField(); i++ {
+		if field := rv.Type().Field(i); field.IsExported() {
+			fields[normalizeOption(field.Name)] = i
+		}
+	}
+
+	// Sorted so the first error is the same on every run
+	var keys = make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		i, ok := fields[normalizeOption(key)]
+		if !ok {
+			return fmt.Errorf("%w: unknown option %q", ErrInvalidOptions, key)
+		}
+		if err := setOption(rv.Field(i), options[key]); err != nil {
+			return fmt.Errorf("%w: option %q: %v", ErrInvalidOptions, key, err)
+		}
+	}
+	return nil
+}
+
+func normalizeOption(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setOption(field reflect.Value, value interface{}) error {
+	if field.Type() == durationType {
+		d, err := parseDurationOption(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(fmt.Sprint(value))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(fmt.Sprint(value))
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := parseIntOption(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("%s cannot be configured", field.Type())
+		}
+		var items []string
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+		case string:
+			for _, item := range strings.Split(v, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+		default:
+			return fmt.Errorf("expected a list, got %T", value)
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected a mapping, got %T", value)
+		}
+		return DecodeOptions(m, field.Addr().Interface())
+	default:
+		return fmt.Errorf("%s cannot be configured", field.Type())
+	}
+	return nil
+}
+
+// parseIntOption accepts whole float64 numbers, which is how JSON decodes
+// every number, such as 1e+06
+func parseIntOption(value interface{}) (int64, error) {
+	if f, ok := value.(float64); ok {
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("expected an integer, got %v", f)
+		}
+		return int64(f), nil
+	}
+	return strconv.ParseInt(fmt.Sprint(value), 10, 64)
+}
+
+func parseDurationOption(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case string:
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(seconds * float64(time.Second)), nil
+		}
+		return time.ParseDuration(v)
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	}
+	return 0, fmt.Errorf("expected a duration, got %T", value)
+}
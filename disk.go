@@ -0,0 +1,684 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	diskEntryVersion    = 1
+	diskEntryHeaderSize = 17
+	diskTempSuffix      = ".tmp"
+)
+
+var errDiskCorrupted = errors.New("cache: Disk entry is corrupted")
+
+// DiskStore keeps one file per entry under Dir, sharded in 256 directories by
+// the hash of the key. Writes go to a temporary file which is synced and then
+// renamed over the entry, so a crash leaves either the previous or the new
+// value. The index of the entries is rebuilt from the files on open, entries
+// that are expired, corrupted or half written are removed
+type DiskStore struct {
+	dir       string
+	maxSize   int64
+	noSync    bool
+	log       storeLogger
+	codec     Codec
+	stop      chan struct{}
+	closeOnce sync.Once
+	now       func() time.Time
+
+	scans   scanSnapshots
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds *diskEntry, the front is the most recently used
+	lru  *list.List
+	size int64
+	// expired holds the keys alive removed under mu, fired once it is released
+	expired []string
+
+	DefaultExpiration time.Duration
+}
+
+type diskEntry struct {
+	key  string
+	path string
+	size int64
+	// expiredAt is in unix nanoseconds, 0 never expires
+	expiredAt int64
+}
+
+type DiskStoreOptions struct {
+	// Dir holds the entries, it is created if missing
+	Dir               string
+	DefaultExpiration time.Duration
+
+	// MaxSize bounds the bytes of the entry files, the least recently used
+	// entries are evicted past it. Default is 0, unbounded
+	MaxSize int64
+	// CompactionInterval is the period of the background removal of expired entries.
+	// Default is 1 minute; -1 disables it, expired entries are then removed when read
+	CompactionInterval time.Duration
+	// NoSync skips fsync, writes are faster but may be lost on power failure
+	NoSync bool
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes, evictions and failures
+	Events *Events
+}
+
+var DiskStoreOptionsDefault = &DiskStoreOptions{
+	CompactionInterval: time.Minute,
+}
+
+// NewDiskStore panics on invalid options, use OpenDiskStore to handle them
+func NewDiskStore(options DiskStoreOptions, opts ...Option) *DiskStore {
+	store, err := OpenDiskStore(options, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// OpenDiskStore validates options, creates Dir and loads the index of the entries
+func OpenDiskStore(options DiskStoreOptions, opts ...Option) (*DiskStore, error) {
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+	}.apply("disk", opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case options.Dir == "":
+		return nil, invalidOptions("disk", "Dir is required")
+	case options.MaxSize < 0:
+		return nil, invalidOptions("disk", "MaxSize must not be negative, got %d", options.MaxSize)
+	}
+
+	if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var store = &DiskStore{
+		dir:               options.Dir,
+		maxSize:           options.MaxSize,
+		noSync:            options.NoSync,
+		log:               newStoreLogger("disk", config.Logger, config.SlowThreshold, config.Events),
+		codec:             config.Codec,
+		stop:              make(chan struct{}),
+		now:               time.Now,
+		entries:           make(map[string]*list.Element),
+		lru:               list.New(),
+		DefaultExpiration: config.DefaultExpiration,
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	var interval = options.CompactionInterval
+	if interval == 0 {
+		interval = DiskStoreOptionsDefault.CompactionInterval
+	}
+	if interval > 0 {
+		go store.compactor(interval)
+	}
+
+	return store, nil
+}
+
+func (c *DiskStore) Get(key string, value interface{}) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+// GetBytes reads the entry file and marks the entry as recently used
+func (c *DiskStore) GetBytes(key string) (_ []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
+	value, _, err := c.read(key)
+	return value, err
+}
+
+func (c *DiskStore) Set(key string, value interface{}, expiration ...time.Duration) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return ErrMarshal
+	}
+
+	return c.SetBytes(key, bytes, expiration...)
+}
+
+// SetBytes writes the entry file, an entry larger than MaxSize returns ErrNotStored
+func (c *DiskStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	return c.write(key, bytes, c.expiredAt(exp), nil)
+}
+
+func (c *DiskStore) Delete(key string) (err error) {
+	defer c.log.track("delete", key, time.Now(), &err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.entries[key]; found {
+		return c.remove(element)
+	}
+	return nil
+}
+
+func (c *DiskStore) Exists(key string) (bool, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	_, found := c.alive(key)
+	return found, nil
+}
+
+func (c *DiskStore) TTL(key string) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.unlock()
+
+	entry, found := c.alive(key)
+	if !found {
+		return 0, ErrKeyNotFound
+	}
+
+	if entry.expiredAt == 0 {
+		return NoExpiration, nil
+	}
+	return time.Duration(entry.expiredAt - c.now().UnixNano()), nil
+}
+
+// Touch rewrites the entry with the new lifetime, the value is not re-encoded.
+// A Delete or Set racing with Touch wins
+func (c *DiskStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	value, entry, err := c.read(key)
+	if err != nil {
+		return err
+	}
+
+	if ttl < 0 {
+		ttl = NoExpiration
+	}
+	return c.write(key, value, c.expiredAt(ttl), entry)
+}
+
+// Clear removes every entry file, the shard directories are kept
+func (c *DiskStore) Clear() (err error) {
+	defer c.log.track("clear", "", time.Now(), &err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, element := range c.entries {
+		if err := c.remove(element); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan pages through a sorted snapshot of the index taken on the first page,
+// the cursor is the last key returned
+func (c *DiskStore) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var list = func() []string {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		var now = c.now().UnixNano()
+		var keys []string
+		for key, element := range c.entries {
+			if !element.Value.(*diskEntry).expired(now) && re.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	var alive = func(key string) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		element, found := c.entries[key]
+		return found && !element.Value.(*diskEntry).expired(c.now().UnixNano())
+	}
+
+	keys, next := c.scans.page(pattern, cursor, scanCount(count), list, alive)
+	return keys, next, nil
+}
+
+// Ping checks that Dir is still a directory
+func (c *DiskStore) Ping(ctx context.Context) error {
+	info, err := os.Stat(c.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("cache: " + c.dir + " is not a directory")
+	}
+	return nil
+}
+
+// Close stops the compaction, entries stay on disk for the next OpenDiskStore
+func (c *DiskStore) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	return nil
+}
+
+// Size returns the bytes of the entry files
+func (c *DiskStore) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// Codec returns the codec of values
+func (c *DiskStore) Codec() Codec {
+	return c.codec
+}
+
+func (c *DiskStore) Type() string {
+	return "disk"
+}
+
+// read returns the value of key and its index entry, and marks it as recently used
+func (c *DiskStore) read(key string) ([]byte, *diskEntry, error) {
+	c.mu.Lock()
+	entry, found := c.alive(key)
+	if !found {
+		c.unlock()
+		return nil, nil, ErrKeyNotFound
+	}
+	c.lru.MoveToFront(c.entries[key])
+	var path = entry.path
+	c.unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, ErrKeyNotFound
+		}
+		return nil, nil, err
+	}
+
+	stored, value, _, err := decodeDiskEntry(data)
+	if err == nil && stored != key {
+		err = errDiskCorrupted
+	}
+	if err != nil {
+		c.log.swallowed("get", key, err)
+		c.mu.Lock()
+		if element, found := c.entries[key]; found && element.Value.(*diskEntry).path == path {
+			c.remove(element)
+		}
+		c.mu.Unlock()
+		return nil, nil, ErrKeyNotFound
+	}
+
+	return value, entry, nil
+}
+
+// write stores the entry in a synced temporary file and renames it over the entry.
+// Unless current is nil, the entry is only replaced while the index still holds
+// current: ErrKeyNotFound is returned if it was removed and a newer write is kept
+func (c *DiskStore) write(key string, value []byte, expiredAt int64, current *diskEntry) error {
+	var data = encodeDiskEntry(key, value, expiredAt)
+	if c.maxSize > 0 && int64(len(data)) > c.maxSize {
+		return ErrNotStored
+	}
+
+	var path = c.path(key)
+	var dir = filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*"+diskTempSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if !c.noSync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	var evicted []string
+	c.mu.Lock()
+	if current != nil {
+		element, found := c.entries[key]
+		if !found {
+			c.mu.Unlock()
+			return ErrKeyNotFound
+		}
+		if element.Value.(*diskEntry) != current {
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+
+	var entry = &diskEntry{key: key, path: path, size: int64(len(data)), expiredAt: expiredAt}
+	if element, found := c.entries[key]; found {
+		c.size -= element.Value.(*diskEntry).size
+		element.Value = entry
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[key] = c.lru.PushFront(entry)
+	}
+	c.size += entry.size
+	evicted = c.evict()
+	c.mu.Unlock()
+
+	for _, key := range evicted {
+		c.log.events.fireEvict(key)
+	}
+
+	// The rename is durable once the directory is synced
+	if !c.noSync {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// alive returns the entry of key unless it is missing or expired, an expired
+// entry is removed. It must be called with mu held, released with unlock
+func (c *DiskStore) alive(key string) (*diskEntry, bool) {
+	element, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+
+	var entry = element.Value.(*diskEntry)
+	if entry.expired(c.now().UnixNano()) {
+		c.log.swallowed("expire", key, c.remove(element))
+		c.expired = append(c.expired, key)
+		return nil, false
+	}
+	return entry, true
+}
+
+// unlock releases mu and fires the evictions of the entries alive found expired
+func (c *DiskStore) unlock() {
+	var expired = c.expired
+	c.expired = nil
+	c.mu.Unlock()
+
+	for _, key := range expired {
+		c.log.events.fireEvict(key)
+	}
+}
+
+// evict removes the least recently used entries until the store fits MaxSize,
+// it must be called with mu held and returns the evicted keys
+func (c *DiskStore) evict() []string {
+	var evicted []string
+	for c.maxSize > 0 && c.size > c.maxSize {
+		var element = c.lru.Back()
+		var key = element.Value.(*diskEntry).key
+		c.log.swallowed("evict", key, c.remove(element))
+		evicted = append(evicted, key)
+	}
+	return evicted
+}
+
+// remove deletes the entry file and drops it from the index, it must be called with mu held
+func (c *DiskStore) remove(element *list.Element) error {
+	var entry = element.Value.(*diskEntry)
+	delete(c.entries, entry.key)
+	c.lru.Remove(element)
+	c.size -= entry.size
+
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// compactor removes expired entries every interval until the store is closed
+func (c *DiskStore) compactor(interval time.Duration) {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.compact()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *DiskStore) compact() {
+	var expired []string
+
+	c.mu.Lock()
+	var now = c.now().UnixNano()
+	for key, element := range c.entries {
+		if element.Value.(*diskEntry).expired(now) {
+			c.log.swallowed("compact", key, c.remove(element))
+			expired = append(expired, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, key := range expired {
+		c.log.events.fireEvict(key)
+	}
+}
+
+// load rebuilds the index from the entry files, the least recently modified
+// files are the first to be evicted
+func (c *DiskStore) load() error {
+	type loaded struct {
+		entry   *diskEntry
+		modTime time.Time
+	}
+	var files []loaded
+	var now = c.now().UnixNano()
+
+	shards, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue
+		}
+
+		var shardDir = filepath.Join(c.dir, shard.Name())
+		names, err := os.ReadDir(shardDir)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			var path = filepath.Join(shardDir, name.Name())
+			if strings.HasSuffix(name.Name(), diskTempSuffix) {
+				c.log.swallowed("load", path, os.Remove(path))
+				continue
+			}
+
+			entry, err := readDiskEntryHeader(path)
+			if err != nil || entry.expired(now) || c.path(entry.key) != path {
+				c.log.swallowed("load", path, os.Remove(path))
+				continue
+			}
+
+			info, err := name.Info()
+			if err != nil {
+				return err
+			}
+			files = append(files, loaded{entry: entry, modTime: info.ModTime()})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	for _, file := range files {
+		c.entries[file.entry.key] = c.lru.PushBack(file.entry)
+		c.size += file.entry.size
+	}
+	c.evict()
+
+	return nil
+}
+
+func (c *DiskStore) path(key string) string {
+	var sum = sha256.Sum256([]byte(key))
+	var name = hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+func (c *DiskStore) expiredAt(expiration time.Duration) int64 {
+	if expiration <= 0 {
+		return 0
+	}
+	return c.now().Add(expiration).UnixNano()
+}
+
+func (e *diskEntry) expired(now int64) bool {
+	return e.expiredAt > 0 && e.expiredAt <= now
+}
+
+// encodeDiskEntry lays out an entry file as a header, the key and the value.
+// The header holds the format version, the expiration, the key length and a
+// CRC32 of the key and value
+func encodeDiskEntry(key string, value []byte, expiredAt int64) []byte {
+	var data = make([]byte, diskEntryHeaderSize+len(key)+len(value))
+	data[0] = diskEntryVersion
+	binary.BigEndian.PutUint64(data[1:9], uint64(expiredAt))
+	binary.BigEndian.PutUint32(data[9:13], uint32(len(key)))
+	copy(data[diskEntryHeaderSize:], key)
+	copy(data[diskEntryHeaderSize+len(key):], value)
+	binary.BigEndian.PutUint32(data[13:17], crc32.ChecksumIEEE(data[diskEntryHeaderSize:]))
+	return data
+}
+
+func decodeDiskEntry(data []byte) (string, []byte, int64, error) {
+	if len(data) < diskEntryHeaderSize || data[0] != diskEntryVersion {
+		return "", nil, 0, errDiskCorrupted
+	}
+
+	var keyLen = int(binary.BigEndian.Uint32(data[9:13]))
+	if len(data) < diskEntryHeaderSize+keyLen {
+		return "", nil, 0, errDiskCorrupted
+	}
+	if crc32.ChecksumIEEE(data[diskEntryHeaderSize:]) != binary.BigEndian.Uint32(data[13:17]) {
+		return "", nil, 0, errDiskCorrupted
+	}
+
+	var key = string(data[diskEntryHeaderSize : diskEntryHeaderSize+keyLen])
+	var value = data[diskEntryHeaderSize+keyLen:]
+	return key, value, int64(binary.BigEndian.Uint64(data[1:9])), nil
+}
+
+// readDiskEntryHeader reads the key and expiration of an entry file without its value
+func readDiskEntryHeader(path string) (*diskEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var header = make([]byte, diskEntryHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil || header[0] != diskEntryVersion {
+		return nil, errDiskCorrupted
+	}
+
+	var keyLen = int64(binary.BigEndian.Uint32(header[9:13]))
+	if diskEntryHeaderSize+keyLen > info.Size() {
+		return nil, errDiskCorrupted
+	}
+	var key = make([]byte, keyLen)
+	if _, err := io.ReadFull(f, key); err != nil {
+		return nil, errDiskCorrupted
+	}
+
+	return &diskEntry{
+		key:       string(key),
+		path:      path,
+		size:      info.Size(),
+		expiredAt: int64(binary.BigEndian.Uint64(header[1:9])),
+	}, nil
+}
+
+// syncDir makes the renames in dir durable
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
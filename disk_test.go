@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskStoreReopen(t *testing.T) {
+	var dir = t.TempDir()
+	var store = NewDiskStore(DiskStoreOptions{Dir: dir})
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_reopen", &strIn))
+	assert.NoError(t, store.Set("test_reopen_ttl", &strIn, time.Hour))
+	assert.NoError(t, store.Close())
+
+	store = NewDiskStore(DiskStoreOptions{Dir: dir})
+	defer store.Close()
+
+	var strOut string
+	assert.NoError(t, store.Get("test_reopen", &strOut))
+	assert.Equal(t, strIn, strOut)
+
+	ttl, err := store.TTL("test_reopen_ttl")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+}
+
+func TestDiskStoreExpiration(t *testing.T) {
+	var evicted []string
+	var store = NewDiskStore(DiskStoreOptions{
+		Dir:                t.TempDir(),
+		CompactionInterval: -1,
+		Events: &Events{
+			OnEvict: func(key string) { evicted = append(evicted, key) },
+		},
+	})
+	defer store.Close()
+
+	var now = time.Now()
+	store.now = func() time.Time { return now }
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_expire_read", &strIn, time.Minute))
+	assert.NoError(t, store.Set("test_expire_compact", &strIn, time.Minute))
+	assert.NoError(t, store.Set("test_expire_never", &strIn))
+
+	now = now.Add(2 * time.Minute)
+
+	var strOut string
+	assert.Equal(t, ErrKeyNotFound, store.Get("test_expire_read", &strOut))
+
+	store.compact()
+	assert.ElementsMatch(t, []string{"test_expire_read", "test_expire_compact"}, evicted)
+	assert.NoError(t, store.Get("test_expire_never", &strOut))
+
+	keys, _, err := store.Scan(context.Background(), "test_expire_*", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test_expire_never"}, keys)
+}
+
+func TestDiskStoreEvictCallback(t *testing.T) {
+	var store *DiskStore
+	var found = true
+	store = NewDiskStore(DiskStoreOptions{
+		Dir:                t.TempDir(),
+		CompactionInterval: -1,
+		Events: &Events{
+			// Calls back into the store, which must not hold its lock
+			OnEvict: func(key string) { found, _ = store.Exists(key) },
+		},
+	})
+	defer store.Close()
+
+	var now = time.Now()
+	store.now = func() time.Time { return now }
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_evict_callback", &strIn, time.Minute))
+	now = now.Add(2 * time.Minute)
+
+	exists, err := store.Exists("test_evict_callback")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.False(t, found)
+}
+
+func TestDiskStoreMaxSize(t *testing.T) {
+	var value = make([]byte, 100)
+	var entrySize = int64(len(encodeDiskEntry("test_lru_1", value, 0)))
+
+	var evicted []string
+	var store = NewDiskStore(DiskStoreOptions{
+		Dir:     t.TempDir(),
+		MaxSize: 3 * entrySize,
+		Events: &Events{
+			OnEvict: func(key string) { evicted = append(evicted, key) },
+		},
+	})
+	defer store.Close()
+
+	assert.NoError(t, store.SetBytes("test_lru_1", value))
+	assert.NoError(t, store.SetBytes("test_lru_2", value))
+	assert.NoError(t, store.SetBytes("test_lru_3", value))
+
+	// Reading test_lru_1 makes test_lru_2 the least recently used
+	_, err := store.GetBytes("test_lru_1")
+	assert.NoError(t, err)
+	assert.NoError(t, store.SetBytes("test_lru_4", value))
+
+	assert.Equal(t, []string{"test_lru_2"}, evicted)
+	assert.Equal(t, 3*entrySize, store.Size())
+
+	found, err := store.Exists("test_lru_2")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.Equal(t, ErrNotStored, store.SetBytes("test_lru_big", make([]byte, 4*entrySize)))
+}
+
+func TestDiskStoreRecovery(t *testing.T) {
+	var dir = t.TempDir()
+	var store = NewDiskStore(DiskStoreOptions{Dir: dir})
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_recovery_ok", &strIn))
+	assert.NoError(t, store.Set("test_recovery_corrupt", &strIn))
+	assert.NoError(t, store.Close())
+
+	// A write interrupted before the rename and a truncated entry
+	var corrupt = store.path("test_recovery_corrupt")
+	var tmp = corrupt + ".123" + diskTempSuffix
+	assert.NoError(t, os.WriteFile(tmp, []byte("partial"), 0o644))
+	assert.NoError(t, os.Truncate(corrupt, diskEntryHeaderSize+4))
+
+	store = NewDiskStore(DiskStoreOptions{Dir: dir})
+	defer store.Close()
+
+	var strOut string
+	assert.NoError(t, store.Get("test_recovery_ok", &strOut))
+	assert.Equal(t, ErrKeyNotFound, store.Get("test_recovery_corrupt", &strOut))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{store.path("test_recovery_ok")}, files)
+}
+
+func TestDiskStoreTouchRace(t *testing.T) {
+	var dir = t.TempDir()
+	var store = NewDiskStore(DiskStoreOptions{Dir: dir})
+
+	var strIn, strNew = "Hello world", "Hello again"
+	for i := 0; i < 200; i++ {
+		var key = fmt.Sprintf("test_touch_race_%d", i)
+		assert.NoError(t, store.Set(key, &strIn))
+
+		var wg sync.WaitGroup
+		var touchErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			touchErr = store.Touch(key, time.Hour)
+		}()
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				assert.NoError(t, store.Delete(key))
+			} else {
+				assert.NoError(t, store.Set(key, &strNew))
+			}
+		}()
+		wg.Wait()
+
+		// A deleted key stays deleted and a newer value is kept
+		var strOut string
+		if i%2 == 0 {
+			assert.True(t, touchErr == nil || touchErr == ErrKeyNotFound, touchErr)
+			assert.Equal(t, ErrKeyNotFound, store.Get(key, &strOut))
+		} else {
+			assert.NoError(t, touchErr)
+			assert.NoError(t, store.Get(key, &strOut))
+			assert.Equal(t, strNew, strOut)
+		}
+	}
+
+	// The same interleavings, with the write of Touch after the Delete or Set
+	assert.NoError(t, store.Set("test_touch_deleted", &strIn))
+	value, entry, err := store.read("test_touch_deleted")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Delete("test_touch_deleted"))
+	assert.Equal(t, ErrKeyNotFound, store.write("test_touch_deleted", value, 0, entry))
+	exists, _ := store.Exists("test_touch_deleted")
+	assert.False(t, exists)
+
+	assert.NoError(t, store.Set("test_touch_set", &strIn))
+	value, entry, err = store.read("test_touch_set")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Set("test_touch_set", &strNew))
+	assert.NoError(t, store.write("test_touch_set", value, 0, entry))
+	var strOut string
+	assert.NoError(t, store.Get("test_touch_set", &strOut))
+	assert.Equal(t, strNew, strOut)
+	assert.NoError(t, store.Close())
+
+	// The files of deleted keys are gone
+	store = NewDiskStore(DiskStoreOptions{Dir: dir})
+	defer store.Close()
+	keys, _, err := store.Scan(context.Background(), "test_touch_race_*", "", 0)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 100)
+}
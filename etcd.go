@@ -0,0 +1,458 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// EtcdStore keeps entries in etcd. An entry with an expiration is attached to
+// a lease of its own, etcd deletes the key when the lease expires. Leases have
+// a precision of one second and the server may round short ones up.
+// Values are limited by the request size of the server, 1.5 MiB by default
+type EtcdStore struct {
+	client            *clientv3.Client
+	ownsClient        bool
+	prefix            string
+	timeouts          Timeouts
+	log               storeLogger
+	codec             Codec
+	closeOnce         sync.Once
+	DefaultExpiration time.Duration
+}
+
+type EtcdStoreOptions struct {
+	// Client is a connected client, Close leaves it open
+	Client *clientv3.Client
+	// Endpoints are dialed when Client is nil
+	Endpoints   []string
+	Username    string
+	Password    string
+	DialTimeout time.Duration
+
+	// Prefix is prepended to every key and scopes Clear, Scan and Watch to the
+	// store's namespace. Clear is refused without a prefix.
+	Prefix string
+
+	DefaultExpiration time.Duration
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes and failures
+	Events *Events
+
+	// Timeouts bound reads and writes, default is TimeoutsDefault.
+	// Operations running out of time return ErrTimeout.
+	Timeouts Timeouts
+}
+
+var EtcdStoreOptionsDefault = &EtcdStoreOptions{
+	DialTimeout: 5 * time.Second,
+}
+
+// NewEtcdStore panics on invalid options or when no endpoint can be dialed,
+// use OpenEtcdStore to handle them
+func NewEtcdStore(options EtcdStoreOptions, opts ...Option) *EtcdStore {
+	store, err := OpenEtcdStore(options, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// OpenEtcdStore validates options, connects when no Client is given and
+// checks the cluster status within the read timeout
+func OpenEtcdStore(options EtcdStoreOptions, opts ...Option) (*EtcdStore, error) {
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+		Timeouts:          options.Timeouts,
+	}.apply("etcd", opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case options.Client == nil && len(options.Endpoints) == 0:
+		return nil, invalidOptions("etcd", "Client or Endpoints is required")
+	case options.DialTimeout < 0:
+		return nil, invalidOptions("etcd", "DialTimeout must not be negative, got %v", options.DialTimeout)
+	}
+
+	var client = options.Client
+	if client == nil {
+		var dialTimeout = options.DialTimeout
+		if dialTimeout == 0 {
+			dialTimeout = EtcdStoreOptionsDefault.DialTimeout
+		}
+
+		client, err = clientv3.New(clientv3.Config{
+			Endpoints:   options.Endpoints,
+			Username:    options.Username,
+			Password:    options.Password,
+			DialTimeout: dialTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var store = &EtcdStore{
+		client:            client,
+		ownsClient:        options.Client == nil,
+		prefix:            options.Prefix,
+		timeouts:          config.Timeouts.orDefault(TimeoutsDefault),
+		log:               newStoreLogger("etcd", config.Logger, config.SlowThreshold, config.Events),
+		codec:             config.Codec,
+		DefaultExpiration: config.DefaultExpiration,
+	}
+	store.log.timedOut = isEtcdTimeout
+
+	if err := store.Ping(context.Background()); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (c *EtcdStore) Get(key string, value interface{}) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+func (c *EtcdStore) GetBytes(key string) (_ []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	resp, err := c.client.Get(ctx, c.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	return resp.Kvs[0].Value, nil
+}
+
+func (c *EtcdStore) Set(key string, value interface{}, expiration ...time.Duration) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return ErrMarshal
+	}
+
+	return c.SetBytes(key, bytes, expiration...)
+}
+
+// SetBytes puts bytes with a new lease when the entry expires, the lease of
+// the previous value is revoked
+func (c *EtcdStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	lease, err := c.grant(ctx, exp)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Put(ctx, c.prefix+key, string(bytes), clientv3.WithLease(lease), clientv3.WithPrevKV())
+	if err != nil {
+		return err
+	}
+	if resp.PrevKv != nil {
+		c.revoke(ctx, key, clientv3.LeaseID(resp.PrevKv.Lease))
+	}
+	return nil
+}
+
+func (c *EtcdStore) Delete(key string) (err error) {
+	defer c.log.track("delete", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	resp, err := c.client.Delete(ctx, c.prefix+key, clientv3.WithPrevKV())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.PrevKvs {
+		c.revoke(ctx, key, clientv3.LeaseID(kv.Lease))
+	}
+	return nil
+}
+
+func (c *EtcdStore) Exists(key string) (_ bool, err error) {
+	defer c.log.track("exists", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	resp, err := c.client.Get(ctx, c.prefix+key, clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return resp.Count > 0, nil
+}
+
+// TTL is the remaining time of the lease of the entry, in whole seconds
+func (c *EtcdStore) TTL(key string) (_ time.Duration, err error) {
+	defer c.log.track("ttl", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	resp, err := c.client.Get(ctx, c.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, ErrKeyNotFound
+	}
+	if resp.Kvs[0].Lease == 0 {
+		return NoExpiration, nil
+	}
+
+	lease, err := c.client.TimeToLive(ctx, clientv3.LeaseID(resp.Kvs[0].Lease))
+	if err != nil {
+		return 0, err
+	}
+	// The lease expired between the two reads
+	if lease.TTL < 0 {
+		return 0, ErrKeyNotFound
+	}
+	return time.Duration(lease.TTL) * time.Second, nil
+}
+
+// Touch moves the entry to a new lease in a transaction on its revision, a
+// write racing with Touch wins and keeps its own expiration, a delete racing
+// with it returns ErrKeyNotFound
+func (c *EtcdStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	resp, err := c.client.Get(ctx, c.prefix+key)
+	if err != nil {
+		return err
+	}
+	if len(resp.Kvs) == 0 {
+		return ErrKeyNotFound
+	}
+	var kv = resp.Kvs[0]
+
+	lease, err := c.grant(ctx, ttl)
+	if err != nil {
+		return err
+	}
+
+	txn, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(c.prefix+key), "=", kv.ModRevision)).
+		Then(clientv3.OpPut(c.prefix+key, string(kv.Value), clientv3.WithLease(lease))).
+		Else(clientv3.OpGet(c.prefix+key, clientv3.WithCountOnly())).
+		Commit()
+	if err != nil {
+		return err
+	}
+
+	if txn.Succeeded {
+		c.revoke(ctx, key, clientv3.LeaseID(kv.Lease))
+		return nil
+	}
+
+	// The key changed since it was read, a newer write wins but a deleted or
+	// expired key is missing
+	c.revoke(ctx, key, lease)
+	if txn.Responses[0].GetResponseRange().Count == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// Clear deletes every key under the store prefix, it is refused without a prefix
+func (c *EtcdStore) Clear() (err error) {
+	defer c.log.track("clear", "", time.Now(), &err)
+
+	if c.prefix == "" {
+		return ErrClearNotAllowed
+	}
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	_, err = c.client.Delete(ctx, c.prefix, clientv3.WithPrefix())
+	return err
+}
+
+// Scan reads the keys under the store prefix in order by pages of count and
+// matches them against the pattern, the cursor is the last key returned
+func (c *EtcdStore) Scan(ctx context.Context, pattern string, cursor string, count int) (_ []string, _ string, err error) {
+	defer c.log.track("scan", pattern, time.Now(), &err)
+
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return nil, "", err
+	}
+	count = scanCount(count)
+
+	ctx, cancel := c.timeouts.read(ctx)
+	defer cancel()
+
+	// The range starts right after the cursor, a key followed by a zero byte
+	var from = c.prefix
+	if cursor != "" {
+		from = c.prefix + cursor + "\x00"
+	}
+	var end = clientv3.GetPrefixRangeEnd(c.prefix)
+
+	var keys []string
+	for {
+		resp, err := c.client.Get(ctx, from, clientv3.WithRange(end), clientv3.WithKeysOnly(), clientv3.WithLimit(int64(count)))
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, kv := range resp.Kvs {
+			var key = strings.TrimPrefix(string(kv.Key), c.prefix)
+			if !re.MatchString(key) {
+				continue
+			}
+			if len(keys) == count {
+				return keys, keys[count-1], nil
+			}
+			keys = append(keys, key)
+		}
+
+		if !resp.More {
+			return keys, "", nil
+		}
+		from = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// Watch calls onChange with the key of every entry written, deleted or
+// expired under the store prefix, by any client, until ctx is done. It is
+// meant to invalidate copies kept in a local tier, such as
+//
+//	go store.Watch(ctx, func(key string) { local.Delete(key) })
+//
+// Watch returns ctx.Err() once ctx is done, or the error ending the watch
+func (c *EtcdStore) Watch(ctx context.Context, onChange func(key string)) error {
+	var changes = c.client.Watch(clientv3.WithRequireLeader(ctx), c.prefix, clientv3.WithPrefix())
+	for resp := range changes {
+		if err := resp.Err(); err != nil {
+			c.log.swallowed("watch", "", err)
+			return err
+		}
+		for _, event := range resp.Events {
+			onChange(strings.TrimPrefix(string(event.Kv.Key), c.prefix))
+		}
+	}
+	return ctx.Err()
+}
+
+// Ping checks the status of the first endpoint within the read timeout
+func (c *EtcdStore) Ping(ctx context.Context) (err error) {
+	defer c.log.track("ping", "", time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(ctx)
+	defer cancel()
+
+	var endpoints = c.client.Endpoints()
+	if len(endpoints) == 0 {
+		return errors.New("cache: etcd client has no endpoint")
+	}
+	_, err = c.client.Status(ctx, endpoints[0])
+	return err
+}
+
+// Close closes the client only when the store created it
+func (c *EtcdStore) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		if c.ownsClient {
+			err = c.client.Close()
+		}
+	})
+	return err
+}
+
+// Codec returns the codec of values
+func (c *EtcdStore) Codec() Codec {
+	return c.codec
+}
+
+func (c *EtcdStore) Type() string {
+	return "etcd"
+}
+
+// grant creates the lease of an entry expiring after expiration, rounded up
+// to the second. No lease is needed for an entry which never expires
+func (c *EtcdStore) grant(ctx context.Context, expiration time.Duration) (clientv3.LeaseID, error) {
+	if expiration <= 0 {
+		return clientv3.NoLease, nil
+	}
+
+	var seconds = int64((expiration + time.Second - 1) / time.Second)
+	resp, err := c.client.Grant(ctx, seconds)
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	return resp.ID, nil
+}
+
+// revoke releases the lease of a replaced or deleted entry, a failure only
+// leaves the lease to expire on its own
+func (c *EtcdStore) revoke(ctx context.Context, key string, lease clientv3.LeaseID) {
+	if lease == clientv3.NoLease {
+		return
+	}
+
+	_, err := c.client.Revoke(ctx, lease)
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		return
+	}
+	c.log.swallowed("revoke", key, err)
+}
+
+// isEtcdTimeout recognizes deadlines reported by the server as well as local ones
+func isEtcdTimeout(err error) bool {
+	return isTimeout(err) || status.Code(err) == codes.DeadlineExceeded
+}
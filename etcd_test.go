@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// startEtcd runs a single member etcd server in a temporary directory and
+// returns its client endpoint
+func startEtcd(t *testing.T) string {
+	var config = embed.NewConfig()
+	config.Dir = t.TempDir()
+	config.LogLevel = "error"
+
+	var clientURL, peerURL = freeURL(t), freeURL(t)
+	config.LCUrls = []url.URL{clientURL}
+	config.ACUrls = []url.URL{clientURL}
+	config.LPUrls = []url.URL{peerURL}
+	config.APUrls = []url.URL{peerURL}
+	config.InitialCluster = config.InitialClusterFromName(config.Name)
+
+	server, err := embed.StartEtcd(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd did not start")
+	}
+	return clientURL.Host
+}
+
+func freeURL(t *testing.T) url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return url.URL{Scheme: "http", Host: listener.Addr().String()}
+}
+
+func TestEtcdStoreLeases(t *testing.T) {
+	var store = NewEtcdStore(EtcdStoreOptions{Endpoints: []string{startEtcd(t)}, Prefix: "cache/"})
+	defer store.Close()
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_lease", &strIn, time.Second))
+
+	resp, err := store.client.Get(context.Background(), "cache/test_lease")
+	assert.NoError(t, err)
+	var lease = resp.Kvs[0].Lease
+	assert.NotZero(t, lease)
+
+	// Overwriting without expiration revokes the previous lease
+	assert.NoError(t, store.Set("test_lease", &strIn))
+	ttl, err := store.TTL("test_lease")
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	leases, err := store.client.Leases(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, leases.Leases)
+
+	// Touch moves the entry to a lease which etcd expires
+	assert.NoError(t, store.Touch("test_lease", time.Second))
+	assert.Eventually(t, func() bool {
+		found, err := store.Exists("test_lease")
+		return err == nil && !found
+	}, 10*time.Second, 100*time.Millisecond)
+
+	assert.Equal(t, ErrKeyNotFound, store.Touch("test_lease", time.Minute))
+
+	// Closing twice is fine
+	assert.NoError(t, store.Close())
+	assert.NoError(t, store.Close())
+}
+
+func TestEtcdStoreWatch(t *testing.T) {
+	var store = NewEtcdStore(EtcdStoreOptions{Endpoints: []string{startEtcd(t)}, Prefix: "cache/"})
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var changed = make(chan string, 10)
+	var done = make(chan error)
+	go func() {
+		done <- store.Watch(ctx, func(key string) { changed <- key })
+	}()
+
+	// A local tier invalidated by the changes seen in etcd
+	var local = NewMemoryStore(MemoryStoreOptions{})
+	defer local.Close()
+
+	var strIn = "Hello world"
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, store.Set("test_watch", &strIn))
+		select {
+		case key := <-changed:
+			return key == "test_watch"
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, local.Set("test_watch", &strIn))
+	assert.NoError(t, store.Delete("test_watch"))
+	assert.NoError(t, local.Delete(<-changed))
+
+	found, err := local.Exists("test_watch")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// Keys outside the prefix are not seen
+	_, err = store.client.Put(context.Background(), "other/test_watch", "value")
+	assert.NoError(t, err)
+	assert.NoError(t, store.Set("test_watch_last", &strIn))
+	assert.Equal(t, "test_watch_last", <-changed)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
+
+func TestEtcdStoreScan(t *testing.T) {
+	var store = NewEtcdStore(EtcdStoreOptions{Endpoints: []string{startEtcd(t)}, Prefix: "cache/"})
+	defer store.Close()
+
+	var strIn = "Hello world"
+	for _, key := range []string{"order:1", "user:1", "order:2", "user:2", "order:3", "user:3"} {
+		assert.NoError(t, store.Set(key, &strIn))
+	}
+
+	keys, cursor, err := store.Scan(context.Background(), "user:*", "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+	assert.Equal(t, "user:2", cursor)
+
+	keys, cursor, err = store.Scan(context.Background(), "user:*", cursor, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:3"}, keys)
+	assert.Equal(t, "", cursor)
+
+	assert.NoError(t, store.Clear())
+	keys, _, err = store.Scan(context.Background(), "*", "", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	var unprefixed = NewEtcdStore(EtcdStoreOptions{Client: store.client})
+	assert.Equal(t, ErrClearNotAllowed, unprefixed.Clear())
+	assert.NoError(t, unprefixed.Close())
+	assert.NoError(t, store.Ping(context.Background()))
+}
@@ -0,0 +1,45 @@
+package cache
+
+// Events are callbacks fired by stores. Hits and misses are reported for
+// reads, OnSet for Set, SetBytes, Add, Replace, CompareAndSwap and counters,
+// and OnError for failed operations, including values Get cannot decode.
+// OnEvict is only fired by local stores: MemoryStore (expiry and Delete,
+// through go-cache OnEvicted), RistrettoStore (policy eviction, expiry and Clear), DiskStore
+// (expiry and MaxSize eviction) and BoltStore (expired entries swept)
+type Events struct {
+	OnHit   func(key string)
+	OnMiss  func(key string)
+	OnSet   func(key string)
+	OnEvict func(key string)
+	OnError func(op string, key string, err error)
+}
+
+func (e *Events) fireHit(key string) {
+	if e.OnHit != nil {
+		e.OnHit(key)
+	}
+}
+
+func (e *Events) fireMiss(key string) {
+	if e.OnMiss != nil {
+		e.OnMiss(key)
+	}
+}
+
+func (e *Events) fireSet(key string) {
+	if e.OnSet != nil {
+		e.OnSet(key)
+	}
+}
+
+func (e *Events) fireEvict(key string) {
+	if e.OnEvict != nil {
+		e.OnEvict(key)
+	}
+}
+
+func (e *Events) fireError(op string, key string, err error) {
+	if e.OnError != nil {
+		e.OnError(op, key, err)
+	}
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// StoreFactory builds a store from its Config, opts carry the settings of the
+// Config shared by every store such as the codec. Use DecodeOptions to fill the
+// options struct of the store from config.Options
+type StoreFactory func(config Config, opts ...Option) (Cache, error)
+
+var (
+	registryMu     sync.RWMutex
+	storeFactories = make(map[string]StoreFactory)
+	codecRegistry  = make(map[string]Codec)
+)
+
+// RegisterStore makes a store type available to Build. The name should match
+// the Type() of the store. It panics if the name is empty or already registered
+func RegisterStore(name string, factory StoreFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || name == "chain" || factory == nil {
+		panic("cache: RegisterStore needs a name and a factory")
+	}
+	if _, found := storeFactories[name]; found {
+		panic("cache: RegisterStore called twice for store " + name)
+	}
+	storeFactories[name] = factory
+}
+
+// RegisterCodec makes a codec available to the codec key of a Config
+func RegisterCodec(name string, codec Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || codec == nil {
+		panic("cache: RegisterCodec needs a name and a codec")
+	}
+	if _, found := codecRegistry[name]; found {
+		panic("cache: RegisterCodec called twice for codec " + name)
+	}
+	codecRegistry[name] = codec
+}
+
+// Stores returns the sorted names of the registered store types
+func Stores() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var names = make([]string, 0, len(storeFactories))
+	for name := range storeFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build creates the store or Chain described by config. The codec of a Chain
+// is inherited by the tiers which do not set one. If a tier fails the tiers
+// built before it are closed
+func Build(config Config) (Cache, error) {
+	var opts []Option
+	var codec Codec
+	if config.Codec != "" {
+		registryMu.RLock()
+		var found bool
+		codec, found = codecRegistry[config.Codec]
+		registryMu.RUnlock()
+		if !found {
+			return nil, fmt.Errorf("%w: unknown codec %q", ErrInvalidOptions, config.Codec)
+		}
+		opts = append(opts, WithCodec(codec))
+	}
+
+	if config.Type == "chain" {
+		return buildChain(config, codec)
+	}
+
+	registryMu.RLock()
+	factory, found := storeFactories[config.Type]
+	registryMu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("%w: unknown store type %q, registered types are %v", ErrInvalidOptions, config.Type, Stores())
+	}
+
+	return factory(config, opts...)
+}
+
+func buildChain(config Config, codec Codec) (Cache, error) {
+	if len(config.Options) > 0 {
+		return nil, fmt.Errorf("%w: chain only takes tiers and codec", ErrInvalidOptions)
+	}
+	if len(config.Tiers) == 0 {
+		return nil, fmt.Errorf("%w: chain needs at least one tier", ErrInvalidOptions)
+	}
+
+	var tiers []Cache
+	for i, tierConfig := range config.Tiers {
+		if tierConfig.Codec == "" {
+			tierConfig.Codec = config.Codec
+		}
+
+		tier, err := Build(tierConfig)
+		if err != nil {
+			for _, built := range tiers {
+				built.Close()
+			}
+			return nil, fmt.Errorf("tier %d (%s): %w", i, tierConfig.Type, err)
+		}
+		tiers = append(tiers, tier)
+	}
+
+	var chain = NewChain(tiers...)
+	if codec != nil {
+		chain.SetCodec(codec)
+	}
+	return chain, nil
+}
+
+func init() {
+	RegisterCodec("msgpack", MsgpackCodec{})
+	RegisterCodec("json", JSONCodec{})
+
+	RegisterStore("memory", func(config Config, opts ...Option) (Cache, error) {
+		var options MemoryStoreOptions
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenMemoryStore(options, opts...)
+	})
+	RegisterStore("ristretto", func(config Config, opts ...Option) (Cache, error) {
+		var options = *RistrettoStoreOptionsDefault
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenRistrettoStore(&options, opts...)
+	})
+	RegisterStore("redis", func(config Config, opts ...Option) (Cache, error) {
+		var options RedisStoreOptions
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenRedisStore(&options, opts...)
+	})
+	RegisterStore("memcache", func(config Config, opts ...Option) (Cache, error) {
+		var options MemcacheStoreOptions
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenMemcacheStore(&options, opts...)
+	})
+	RegisterStore("mongodb", func(config Config, opts ...Option) (Cache, error) {
+		var options MongoDBStoreOptions
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenMongoDBStore(options, opts...)
+	})
+	RegisterStore("disk", func(config Config, opts ...Option) (Cache, error) {
+		var options DiskStoreOptions
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenDiskStore(options, opts...)
+	})
+	RegisterStore("badger", func(config Config, opts ...Option) (Cache, error) {
+		var options = *BadgerStoreOptionsDefault
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenBadgerStore(&options, opts...)
+	})
+	RegisterStore("bolt", func(config Config, opts ...Option) (Cache, error) {
+		var options = *BoltStoreOptionsDefault
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenBoltStore(&options, opts...)
+	})
+	RegisterStore("sql", func(config Config, opts ...Option) (Cache, error) {
+		var options SQLStoreOptions
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenSQLStore(options, opts...)
+	})
+	RegisterStore("etcd", func(config Config, opts ...Option) (Cache, error) {
+		var options EtcdStoreOptions
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		return OpenEtcdStore(options, opts...)
+	})
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildFromYAML(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+type: chain
+codec: json
+tiers:
+  - type: memory
+    default_expiration: 1m
+    cleanup_interval: 30s
+  - type: ristretto
+    num_counters: 10000
+    max_cost: 1048576
+    default_expiration: 3600
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "chain", config.Type)
+	assert.Len(t, config.Tiers, 2)
+
+	cache, err := Build(*config)
+	assert.NoError(t, err)
+	defer cache.Close()
+
+	var chain = cache.(*Chain)
+	assert.Equal(t, JSONCodec{}, chain.Codec())
+
+	var memory = chain.caches[0].(*MemoryStore)
+	assert.Equal(t, time.Minute, memory.DefaultExpiration)
+	assert.Equal(t, JSONCodec{}, memory.Codec())
+
+	var ristretto = chain.caches[1].(*RistrettoStore)
+	assert.Equal(t, time.Hour, ristretto.DefaultExpiration)
+	assert.Equal(t, JSONCodec{}, ristretto.Codec())
+}
+
+func TestConfigTierNames(t *testing.T) {
+	var tiers = []Config{
+		{Type: "memory", Options: map[string]interface{}{}},
+		{Type: "ristretto", Options: map[string]interface{}{}},
+	}
+
+	config, err := ParseConfig([]byte(`{type: chain, tiers: [memory, ristretto]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, tiers, config.Tiers)
+
+	var decoded Config
+	assert.NoError(t, json.Unmarshal([]byte(`{"type": "chain", "tiers": ["memory", "ristretto"]}`), &decoded))
+	assert.Equal(t, tiers, decoded.Tiers)
+
+	cache, err := Build(decoded)
+	assert.NoError(t, err)
+	defer cache.Close()
+	assert.IsType(t, &RistrettoStore{}, cache.(*Chain).caches[1])
+
+	_, err = ParseConfig([]byte(`{type: chain, tiers: [memory, 1]}`))
+	assert.EqualError(t, err, "cache: Invalid options: tier 1 must be a name or a mapping")
+}
+
+func TestBuildFromJSON(t *testing.T) {
+	var config Config
+	assert.NoError(t, json.Unmarshal([]byte(`{"type": "memory", "DefaultExpiration": "90s"}`), &config))
+
+	cache, err := Build(config)
+	assert.NoError(t, err)
+	defer cache.Close()
+	assert.Equal(t, 90*time.Second, cache.(*MemoryStore).DefaultExpiration)
+
+	// JSON decodes every number as a float64
+	var decoded map[string]interface{}
+	var options RistrettoStoreOptions
+	assert.NoError(t, json.Unmarshal([]byte(`{"num_counters": 1e6, "max_cost": 1048576, "buffer_items": 64}`), &decoded))
+	assert.NoError(t, DecodeOptions(decoded, &options))
+	assert.Equal(t, RistrettoStoreOptions{NumCounters: 1e6, MaxCost: 1 << 20, BufferItems: 64}, options)
+
+	err = DecodeOptions(map[string]interface{}{"num_counters": 1.5}, &options)
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("TEST_CACHE_TYPE", "chain")
+	t.Setenv("TEST_CACHE_TIERS", "memory, redis")
+	t.Setenv("TEST_CACHE_MEMORY_DEFAULT_EXPIRATION", "1m")
+	t.Setenv("TEST_CACHE_REDIS_ADDRESS", "localhost:6379")
+	t.Setenv("TEST_CACHE_REDIS_ALLOW_FLUSH_DB", "true")
+
+	config, err := ConfigFromEnv("test_cache")
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{
+		Type: "chain",
+		Tiers: []Config{
+			{Type: "memory", Options: map[string]interface{}{"default_expiration": "1m"}},
+			{Type: "redis", Options: map[string]interface{}{"address": "localhost:6379", "allow_flush_db": "true"}},
+		},
+	}, config)
+
+	var options RedisStoreOptions
+	assert.NoError(t, DecodeOptions(config.Tiers[1].Options, &options))
+	assert.Equal(t, "localhost:6379", options.Address)
+	assert.True(t, options.AllowFlushDB)
+}
+
+func TestBuildErrors(t *testing.T) {
+	_, err := Build(Config{Type: "unknown"})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = Build(Config{Type: "memory", Options: map[string]interface{}{"adress": "localhost"}})
+	assert.EqualError(t, err, `cache: Invalid options: unknown option "adress"`)
+
+	_, err = Build(Config{Type: "memory", Options: map[string]interface{}{"logger": "stdout"}})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = Build(Config{Type: "chain", Tiers: []Config{{Type: "memory"}, {Type: "memcache"}}})
+	assert.EqualError(t, err, "tier 1 (memcache): "+ErrMemcacheServerRequired.Error())
+
+	_, err = ParseConfig([]byte(`tiers: []`))
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+type customStore struct {
+	*MemoryStore
+	name string
+}
+
+func (c *customStore) Type() string {
+	return "custom"
+}
+
+func TestRegisterStore(t *testing.T) {
+	RegisterStore("custom", func(config Config, opts ...Option) (Cache, error) {
+		var options struct {
+			Name string
+		}
+		if err := DecodeOptions(config.Options, &options); err != nil {
+			return nil, err
+		}
+		store, err := OpenMemoryStore(MemoryStoreOptions{}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &customStore{MemoryStore: store, name: options.Name}, nil
+	})
+	assert.Contains(t, Stores(), "custom")
+	assert.Panics(t, func() {
+		RegisterStore("custom", func(config Config, opts ...Option) (Cache, error) { return nil, nil })
+	})
+
+	config, err := ParseConfig([]byte(`{"type": "custom", "name": "sessions", "codec": "json"}`))
+	assert.NoError(t, err)
+
+	cache, err := Build(*config)
+	assert.NoError(t, err)
+	assert.Equal(t, "custom", cache.Type())
+	assert.Equal(t, "sessions", cache.(*customStore).name)
+	assert.Equal(t, JSONCodec{}, cache.(*customStore).Codec())
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"reflect"
+	"time"
+)
+
+// Hedged issues a second read when the first one has not answered after a
+// delay and returns whichever answers first, trading load for tail latency.
+// Get, GetBytes, Exists and TTL are hedged, writes go to the cache untouched.
+// Set the delay around the p95 latency of the store so few reads are doubled
+type Hedged struct {
+	Cache
+	delay time.Duration
+}
+
+func NewHedged(cache Cache, delay time.Duration) *Hedged {
+	return &Hedged{
+		Cache: cache,
+		delay: delay,
+	}
+}
+
+// Unwrap returns the wrapped cache
+func (c *Hedged) Unwrap() Cache {
+	return c.Cache
+}
+
+// Get hedges reading the bytes of a single store and decodes the winning
+// read. Over a Chain or another wrapper it hedges its Get into fresh values and
+// copies the winner, concurrent reads never write to value
+func (c *Hedged) Get(key string, value interface{}) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+	if !isStore(c.Cache) {
+		var elem = reflect.TypeOf(value).Elem()
+		winner, err := hedge(c.delay, func() (reflect.Value, error) {
+			var fresh = reflect.New(elem)
+			return fresh, c.Cache.Get(key, fresh.Interface())
+		})
+		if err != nil {
+			return err
+		}
+		reflect.ValueOf(value).Elem().Set(winner.Elem())
+		return nil
+	}
+
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+	return decodeValue(codecOf(c.Cache), c.Cache, bytes, value)
+}
+
+func (c *Hedged) GetBytes(key string) ([]byte, error) {
+	return hedge(c.delay, func() ([]byte, error) {
+		return c.Cache.GetBytes(key)
+	})
+}
+
+func (c *Hedged) Exists(key string) (bool, error) {
+	return hedge(c.delay, func() (bool, error) {
+		return c.Cache.Exists(key)
+	})
+}
+
+func (c *Hedged) TTL(key string) (time.Duration, error) {
+	return hedge(c.delay, func() (time.Duration, error) {
+		return c.Cache.TTL(key)
+	})
+}
+
+type hedgedResult[T any] struct {
+	value T
+	err   error
+}
+
+// hedge calls read, calls it again after delay if it has not returned, and
+// returns the first answer. A failure before delay is returned as is, a failure
+// after it waits for the other read
+func hedge[T any](delay time.Duration, read func() (T, error)) (T, error) {
+	// Buffered so the losing read does not block once hedge has returned
+	var results = make(chan hedgedResult[T], 2)
+	var call = func() {
+		value, err := read()
+		results <- hedgedResult[T]{value: value, err: err}
+	}
+
+	go call()
+	var timer = time.NewTimer(delay)
+	defer timer.Stop()
+
+	var pending = 1
+	var hedged bool
+	var failure *hedgedResult[T]
+	for {
+		select {
+		case <-timer.C:
+			hedged = true
+			pending++
+			go call()
+		case result := <-results:
+			pending--
+			if !isStoreFailure(result.err) {
+				return result.value, result.err
+			}
+			if failure == nil {
+				failure = &result
+			}
+			if !hedged || pending == 0 {
+				return failure.value, failure.err
+			}
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"regexp"
+	"strings"
 )
 
 // ToPtr wraps the given value with pointer: V => *V, *V => **V, etc.
@@ -24,3 +26,49 @@ func printJSON(val interface{}) {
 	data, _ := json.MarshalIndent(val, "", "   ")
 	fmt.Println(string(data))
 }
+
+// globToRegexp converts a glob pattern (*, ? and [...] classes) to an anchored regular expression
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+
+	var escaped, inClass, classStart bool
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case classStart && r == '!':
+			// [!x] is the glob spelling of [^x]
+			classStart = false
+			b.WriteRune('^')
+		case inClass:
+			classStart = false
+			if r == ']' {
+				inClass = false
+			}
+			b.WriteRune(r)
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		case r == '[':
+			inClass, classStart = true, true
+			b.WriteRune(r)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String()
+}
+
+func scanCount(count int) int {
+	if count > 0 {
+		return count
+	}
+	return DefaultScanCount
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// KeyIterator walks every key of a store matching a pattern, one Scan page at a time
+type KeyIterator struct {
+	ctx     context.Context
+	cache   Cache
+	pattern string
+	count   int
+
+	cursor  string
+	started bool
+	page    []string
+	key     string
+	err     error
+}
+
+// Keys returns an iterator over the keys of cache matching the glob pattern
+//
+//	var it = cache.Keys(ctx, store, "user:*")
+//	for it.Next() {
+//		fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+func Keys(ctx context.Context, cache Cache, pattern string) *KeyIterator {
+	return &KeyIterator{
+		ctx:     ctx,
+		cache:   cache,
+		pattern: pattern,
+		count:   DefaultScanCount,
+	}
+}
+
+// PageSize sets the number of keys requested per Scan call
+func (it *KeyIterator) PageSize(count int) *KeyIterator {
+	it.count = count
+	return it
+}
+
+// Next advances to the next key, it returns false when the walk is done or failed
+func (it *KeyIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.err != nil || (it.started && it.cursor == "") {
+			return false
+		}
+
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+
+		it.page, it.cursor, it.err = it.cache.Scan(it.ctx, it.pattern, it.cursor, it.count)
+		it.started = true
+	}
+
+	it.key = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// Key returns the current key
+func (it *KeyIterator) Key() string {
+	return it.key
+}
+
+// Err returns the error that stopped the walk, if any
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
+// maxScanSnapshots bounds the walks a store remembers, the oldest is dropped first
+const maxScanSnapshots = 16
+
+// scanSnapshots keeps the sorted keys of the walks in progress on a local
+// store, so a walk lists and sorts the keyspace once instead of on every page.
+// As with Redis SCAN, keys written after the first page may be missed and keys
+// deleted since are skipped
+type scanSnapshots struct {
+	mu    sync.Mutex
+	walks map[string]*scanSnapshot
+	order []string
+}
+
+type scanSnapshot struct {
+	keys []string
+}
+
+// page returns up to count keys after cursor and the cursor of the next page.
+// list returns the keys matching pattern, alive whether a key still exists
+func (s *scanSnapshots) page(pattern string, cursor string, count int, list func() []string, alive func(key string) bool) ([]string, string) {
+	var snapshot = s.get(pattern, cursor)
+	if snapshot == nil {
+		snapshot = &scanSnapshot{keys: list()}
+		sort.Strings(snapshot.keys)
+		s.put(pattern, snapshot)
+	}
+
+	var keys []string
+	var i = sort.SearchStrings(snapshot.keys, cursor)
+	for ; i < len(snapshot.keys) && len(keys) < count; i++ {
+		if key := snapshot.keys[i]; key > cursor && alive(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	if i == len(snapshot.keys) {
+		s.drop(pattern, snapshot)
+		return keys, ""
+	}
+	return keys, keys[len(keys)-1]
+}
+
+// get returns the snapshot of a walk in progress, a walk starting over gets none
+func (s *scanSnapshots) get(pattern string, cursor string) *scanSnapshot {
+	if cursor == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.walks[pattern]
+}
+
+func (s *scanSnapshots) put(pattern string, snapshot *scanSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.walks == nil {
+		s.walks = make(map[string]*scanSnapshot)
+	}
+	if _, found := s.walks[pattern]; !found {
+		s.order = append(s.order, pattern)
+	}
+	s.walks[pattern] = snapshot
+
+	if len(s.order) > maxScanSnapshots {
+		delete(s.walks, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// drop forgets a finished walk, unless another walk replaced its snapshot
+func (s *scanSnapshots) drop(pattern string, snapshot *scanSnapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.walks[pattern] != snapshot {
+		return
+	}
+	delete(s.walks, pattern)
+	for i, p := range s.order {
+		if p == pattern {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
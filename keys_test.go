@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeys(t *testing.T) {
+	var store = NewMemoryStore(MemoryStoreOptions{})
+
+	var strIn = "Hello world"
+	for i := 0; i < 25; i++ {
+		assert.NoError(t, store.Set(fmt.Sprintf("user:%02d", i), &strIn))
+	}
+	assert.NoError(t, store.Set("order:01", &strIn))
+
+	var keys []string
+	var it = Keys(context.Background(), store, "user:*").PageSize(10)
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.NoError(t, it.Err())
+	assert.Len(t, keys, 25)
+	assert.Equal(t, "user:00", keys[0])
+	assert.Equal(t, "user:24", keys[24])
+
+	page, next, err := store.Scan(context.Background(), "user:1?", "", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:10", "user:11", "user:12", "user:13", "user:14"}, page)
+	assert.Equal(t, "user:14", next)
+
+	// A walk skips keys deleted after its first page
+	page, next, err = store.Scan(context.Background(), "user:*", "", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "user:09", next)
+	assert.NoError(t, store.Delete("user:10"))
+	page, _, err = store.Scan(context.Background(), "user:*", next, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:11", "user:12"}, page)
+
+	page, _, err = store.Scan(context.Background(), "user:2[!0-2]", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:23", "user:24"}, page)
+
+	// Chain skips tiers that cannot list keys
+	var chain = NewChain(NewRistrettoStore(RistrettoStoreOptionsDefault), store)
+	it = Keys(context.Background(), chain, "order:*")
+	assert.True(t, it.Next())
+	assert.Equal(t, "order:01", it.Key())
+	assert.False(t, it.Next())
+
+	// Chain lists the last tier, upper tiers only hold a subset of it
+	var l1 = NewMemoryStore(MemoryStoreOptions{})
+	assert.NoError(t, l1.Set("order:01", &strIn))
+	assert.NoError(t, store.Set("order:02", &strIn))
+	page, _, err = NewChain(l1, store).Scan(context.Background(), "order:*", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"order:01", "order:02"}, page)
+
+	_, _, err = NewRistrettoStore(RistrettoStoreOptionsDefault).Scan(context.Background(), "*", "", 0)
+	assert.Equal(t, ErrNotSupported, err)
+}
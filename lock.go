@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Lock errors
+var (
+	ErrLockHeld    = errors.New("cache: Lock is held by another owner")
+	ErrLockNotHeld = errors.New("cache: Lock is no longer held")
+)
+
+// LockStore is implemented by stores a Locker can run on: leases use
+// conditional writes and fencing tokens use counters
+type LockStore interface {
+	ConditionalWriter
+	Counter
+}
+
+type lockLease struct {
+	Owner string
+}
+
+type LockerOptions struct {
+	// Prefix is prepended to lock names to build the lease keys
+	Prefix string
+	// RetryInterval is the wait between acquire attempts in Lock
+	RetryInterval time.Duration
+	// AutoRefresh renews the lease every third of its ttl until Unlock
+	AutoRefresh bool
+	// Logger receives renewal failures and lost leases, default is DefaultLogger
+	Logger Logger
+}
+
+var LockerOptionsDefault = &LockerOptions{
+	Prefix:        "lock:",
+	RetryInterval: 100 * time.Millisecond,
+	AutoRefresh:   true,
+}
+
+// Locker provides mutual exclusion across processes sharing a store
+type Locker struct {
+	store         LockStore
+	prefix        string
+	retryInterval time.Duration
+	autoRefresh   bool
+	logger        Logger
+}
+
+func NewLocker(store LockStore, options *LockerOptions) *Locker {
+	if options == nil {
+		options = LockerOptionsDefault
+	}
+
+	var locker = &Locker{
+		store:         store,
+		prefix:        options.Prefix,
+		retryInterval: options.RetryInterval,
+		autoRefresh:   options.AutoRefresh,
+		logger:        options.Logger,
+	}
+	if locker.retryInterval <= 0 {
+		locker.retryInterval = LockerOptionsDefault.RetryInterval
+	}
+	if locker.logger == nil {
+		locker.logger = DefaultLogger
+	}
+
+	return locker
+}
+
+// lockMinRenewInterval keeps the renewal of a very short lease from spinning
+const lockMinRenewInterval = time.Millisecond
+
+// Lock blocks until the lock is acquired for ttl or ctx is done
+func (l *Locker) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	if err := validateLockTTL(ttl); err != nil {
+		return nil, err
+	}
+
+	for {
+		lock, err := l.TryLock(name, ttl)
+		if err != ErrLockHeld {
+			return lock, err
+		}
+
+		var timer = time.NewTimer(l.retryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// TryLock acquires the lock for ttl or returns ErrLockHeld without waiting
+func (l *Locker) TryLock(name string, ttl time.Duration) (*Lock, error) {
+	if err := validateLockTTL(ttl); err != nil {
+		return nil, err
+	}
+
+	owner, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	var key = l.prefix + name
+	var lease = lockLease{Owner: owner}
+	if err := l.store.Add(key, &lease, ttl); err != nil {
+		if err == ErrNotStored {
+			return nil, ErrLockHeld
+		}
+		return nil, err
+	}
+
+	// The fence is incremented while holding the lease so it grows with every
+	// acquisition, it never expires so it does not start over
+	fence, err := l.store.Increment(key+":fence", 1, NoExpiration)
+	if err != nil {
+		if err := l.release(key, owner); err != nil {
+			l.logger.Warn("cache: ignored failure", "op", "lock_release", "key", key, "error", err)
+		}
+		return nil, err
+	}
+
+	var lock = &Lock{
+		locker: l,
+		key:    key,
+		owner:  owner,
+		fence:  fence,
+		ttl:    ttl,
+		done:   make(chan struct{}),
+	}
+	if l.autoRefresh {
+		go lock.renew()
+	}
+
+	return lock, nil
+}
+
+// release deletes the lease if owner still holds it
+func (l *Locker) release(key string, owner string) error {
+	var lease lockLease
+	token, err := l.store.GetCAS(key, &lease)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return ErrLockNotHeld
+		}
+		return err
+	}
+	if lease.Owner != owner {
+		return ErrLockNotHeld
+	}
+
+	if err := l.store.CompareAndDelete(key, token); err != nil {
+		if err == ErrCASConflict {
+			return ErrLockNotHeld
+		}
+		return err
+	}
+	return nil
+}
+
+// refresh extends the lease to ttl if owner still holds it
+func (l *Locker) refresh(key string, owner string, ttl time.Duration) error {
+	var lease lockLease
+	token, err := l.store.GetCAS(key, &lease)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return ErrLockNotHeld
+		}
+		return err
+	}
+	if lease.Owner != owner {
+		return ErrLockNotHeld
+	}
+
+	if err := l.store.CompareAndSwap(key, &lease, token, ttl); err != nil {
+		if err == ErrCASConflict {
+			return ErrLockNotHeld
+		}
+		return err
+	}
+	return nil
+}
+
+// Lock is a held lease returned by Locker
+type Lock struct {
+	locker *Locker
+	key    string
+	owner  string
+	fence  int64
+
+	mu       sync.Mutex
+	ttl      time.Duration
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// Fence returns the fencing token of this acquisition. Tokens grow with every
+// acquisition of the same name, so downstream writes can reject stale holders
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Done is closed when the lock is released or the lease is lost
+func (l *Lock) Done() <-chan struct{} {
+	return l.done
+}
+
+// Refresh extends the lease to ttl, it returns ErrLockNotHeld once the lease is lost
+func (l *Lock) Refresh(ttl time.Duration) error {
+	if err := validateLockTTL(ttl); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err = l.locker.refresh(l.key, l.owner, ttl)
+	if err == ErrLockNotHeld {
+		l.close()
+	}
+	if err != nil {
+		return err
+	}
+
+	l.ttl = ttl
+	return nil
+}
+
+// Unlock releases the lease, it returns ErrLockNotHeld if the lease was lost
+func (l *Lock) Unlock() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.close()
+	return l.locker.release(l.key, l.owner)
+}
+
+func (l *Lock) close() {
+	l.doneOnce.Do(func() {
+		close(l.done)
+	})
+}
+
+// renew refreshes the lease every third of its ttl until the lock is done
+func (l *Lock) renew() {
+	for {
+		l.mu.Lock()
+		var interval = l.ttl / 3
+		l.mu.Unlock()
+		if interval < lockMinRenewInterval {
+			interval = lockMinRenewInterval
+		}
+
+		var timer = time.NewTimer(interval)
+		select {
+		case <-l.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		l.mu.Lock()
+		var ttl = l.ttl
+		l.mu.Unlock()
+
+		if err := l.Refresh(ttl); err != nil {
+			if err == ErrLockNotHeld {
+				l.locker.logger.Warn("cache: lock lease lost", "key", l.key)
+				return
+			}
+			l.locker.logger.Warn("cache: lock renewal failed", "key", l.key, "error", err)
+		}
+	}
+}
+
+// validateLockTTL rejects leases that would never expire or renew in a tight loop
+func validateLockTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return invalidOptions("lock", "ttl must be positive, got %v", ttl)
+	}
+	return nil
+}
+
+func newLockOwner() (string, error) {
+	var b = make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
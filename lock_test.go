@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocker(t *testing.T) {
+	var store = NewMemoryStore(MemoryStoreOptions{})
+	var locker = NewLocker(store, &LockerOptions{
+		Prefix:        "lock:",
+		RetryInterval: 10 * time.Millisecond,
+		AutoRefresh:   true,
+	})
+
+	lock, err := locker.Lock(context.Background(), "cron", 150*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), lock.Fence())
+
+	_, err = locker.TryLock("cron", time.Second)
+	assert.Equal(t, ErrLockHeld, err)
+
+	// The lease outlives its ttl while it is renewed
+	time.Sleep(300 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = locker.Lock(ctx, "cron", time.Second)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.NoError(t, lock.Unlock())
+	assert.Equal(t, ErrLockNotHeld, lock.Unlock())
+
+	select {
+	case <-lock.Done():
+	default:
+		t.Error("Done is not closed after Unlock")
+	}
+
+	next, err := locker.TryLock("cron", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), next.Fence())
+
+	// A lost lease cannot be refreshed or released by its previous owner
+	assert.NoError(t, store.Delete("lock:cron"))
+	assert.Equal(t, ErrLockNotHeld, next.Refresh(time.Second))
+	assert.Equal(t, ErrLockNotHeld, next.Unlock())
+}
+
+func TestLockerFence(t *testing.T) {
+	var store = NewMemoryStore(MemoryStoreOptions{DefaultExpiration: 50 * time.Millisecond})
+	var locker = NewLocker(store, &LockerOptions{AutoRefresh: false})
+
+	lock, err := locker.TryLock("cron", time.Second)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Unlock())
+
+	// The fence outlives the store default expiration
+	time.Sleep(100 * time.Millisecond)
+	next, err := locker.TryLock("cron", time.Second)
+	assert.NoError(t, err)
+	assert.Greater(t, next.Fence(), lock.Fence())
+	assert.NoError(t, next.Unlock())
+}
+
+func TestLockerOptions(t *testing.T) {
+	var store = NewMemoryStore(MemoryStoreOptions{})
+	var logger = &recordingLogger{}
+	var locker = NewLocker(store, &LockerOptions{Prefix: "lock:", AutoRefresh: true, Logger: logger})
+
+	// Leases must expire, a zero ttl would renew in a tight loop
+	_, err := locker.TryLock("cron", 0)
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = locker.Lock(context.Background(), "cron", -time.Second)
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	lock, err := locker.TryLock("cron", 30*time.Millisecond)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, lock.Refresh(0), ErrInvalidOptions)
+
+	// The lost lease is reported to the locker logger
+	assert.NoError(t, store.Delete("lock:cron"))
+	select {
+	case <-lock.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done is not closed after the lease is lost")
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	assert.Equal(t, []recordedLog{{"warn", "cache: lock lease lost", []interface{}{"key", "lock:cron"}}}, logger.records)
+}
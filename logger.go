@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Logger receives errors, slow operations and swallowed failures. Arguments
+// are alternating key/value pairs, so a *slog.Logger satisfies it as is
+type Logger interface {
+	Warn(msg string, args ...interface{})
+
+	Error(msg string, args ...interface{})
+}
+
+// StdLogger adapts a standard library logger, pairs are printed as key=value
+type StdLogger struct {
+	logger *log.Logger
+}
+
+func NewStdLogger(logger *log.Logger) *StdLogger {
+	return &StdLogger{
+		logger: logger,
+	}
+}
+
+func (l *StdLogger) Warn(msg string, args ...interface{}) {
+	l.print("WARN", msg, args)
+}
+
+func (l *StdLogger) Error(msg string, args ...interface{}) {
+	l.print("ERROR", msg, args)
+}
+
+func (l *StdLogger) print(level string, msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	l.logger.Print(b.String())
+}
+
+// NopLogger discards everything
+type NopLogger struct{}
+
+func (NopLogger) Warn(msg string, args ...interface{}) {}
+
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// storeLogger reports the operations of a store to its Logger and Events
+type storeLogger struct {
+	logger        Logger
+	events        *Events
+	store         string
+	slowThreshold time.Duration
+	// timedOut recognizes the timeouts of the backend, they are reported as ErrTimeout
+	timedOut func(err error) bool
+}
+
+func newStoreLogger(store string, logger Logger, slowThreshold time.Duration, events *Events) storeLogger {
+	if logger == nil {
+		logger = DefaultLogger
+	}
+	if events == nil {
+		events = &Events{}
+	}
+
+	return storeLogger{
+		logger:        logger,
+		events:        events,
+		store:         store,
+		slowThreshold: slowThreshold,
+		timedOut:      isTimeout,
+	}
+}
+
+// track is deferred by store operations with a pointer to their named error.
+// Expected outcomes such as ErrKeyNotFound are not reported as failures and
+// backend timeouts are replaced by ErrTimeout
+func (l storeLogger) track(op string, key string, start time.Time, errp *error) {
+	if *errp != nil && *errp != ErrTimeout && l.timedOut(*errp) {
+		*errp = ErrTimeout
+	}
+
+	var err = *errp
+	if err != nil && !isExpected(err) {
+		l.failed(op, key, err)
+		return
+	}
+
+	switch {
+	case op == "get" && err == nil:
+		l.events.fireHit(key)
+	case op == "get" && err == ErrKeyNotFound:
+		l.events.fireMiss(key)
+	case isWrite(op) && err == nil:
+		l.events.fireSet(key)
+	}
+
+	l.slow(op, key, start)
+}
+
+// failed reports a failed operation, Get calls it for values it cannot decode
+// as their bytes were tracked as a hit
+func (l storeLogger) failed(op string, key string, err error) {
+	l.logger.Error("cache: operation failed", "store", l.store, "op", op, "key", key, "error", err)
+	l.events.fireError(op, key, err)
+}
+
+// slow reports the operation if it took longer than the threshold
+func (l storeLogger) slow(op string, key string, start time.Time) {
+	if l.slowThreshold <= 0 {
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed > l.slowThreshold {
+		l.logger.Warn("cache: slow operation", "store", l.store, "op", op, "key", key, "duration", elapsed)
+	}
+}
+
+// swallowed reports an error that is not returned to the caller
+func (l storeLogger) swallowed(op string, key string, err error) {
+	if err == nil || isExpected(err) {
+		return
+	}
+	l.logger.Warn("cache: ignored failure", "store", l.store, "op", op, "key", key, "error", err)
+	l.events.fireError(op, key, err)
+}
+
+// isWrite reports whether op stores a value, which fires OnSet
+func isWrite(op string) bool {
+	switch op {
+	case "set", "add", "replace", "compare_and_swap", "increment":
+		return true
+	}
+	return false
+}
+
+// isExpected reports whether err is an outcome of the operation rather than a failure
+func isExpected(err error) bool {
+	switch err {
+	case ErrKeyNotFound, ErrNotStored, ErrCASConflict, ErrNotSupported:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"bytes"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordedLog struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	records []recordedLog
+}
+
+func (l *recordingLogger) Warn(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, recordedLog{"warn", msg, args})
+}
+
+func (l *recordingLogger) Error(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, recordedLog{"error", msg, args})
+}
+
+func TestStoreLogger(t *testing.T) {
+	var logger = &recordingLogger{}
+	var store = NewMemoryStore(MemoryStoreOptions{
+		Logger:        logger,
+		SlowThreshold: time.Nanosecond,
+	})
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_logger", &strIn))
+	assert.Len(t, logger.records, 1)
+	assert.Equal(t, "warn", logger.records[0].level)
+	assert.Equal(t, "cache: slow operation", logger.records[0].msg)
+
+	// Misses are not failures
+	var strOut string
+	logger.records = nil
+	store.log.slowThreshold = 0
+	assert.Equal(t, ErrKeyNotFound, store.Get("test_logger_missing", &strOut))
+	assert.Empty(t, logger.records)
+
+	var intOut int
+	assert.Error(t, store.Get("test_logger", &intOut))
+	assert.Len(t, logger.records, 1)
+	assert.Equal(t, "error", logger.records[0].level)
+
+	logger.records = nil
+	store.client.Set("test_logger_float", 3.14, 0)
+	assert.Equal(t, ErrUnmarshal, store.Get("test_logger_float", &strOut))
+	assert.Len(t, logger.records, 1)
+	assert.Equal(t, "error", logger.records[0].level)
+}
+
+func TestChainLogsSwallowedFailures(t *testing.T) {
+	var logger = &recordingLogger{}
+	var l1 = NewMemoryStore(MemoryStoreOptions{Logger: NopLogger{}})
+	var l2 = NewMemoryStore(MemoryStoreOptions{})
+	var chain = NewChain(l1, l2).SetLogger(logger, 0)
+
+	var strIn = "Hello world"
+	l1.client.Set("test_logger", 3.14, 0)
+	assert.NoError(t, l2.Set("test_logger", &strIn))
+
+	var strOut string
+	assert.NoError(t, chain.Get("test_logger", &strOut))
+	assert.Equal(t, strIn, strOut)
+	assert.Len(t, logger.records, 1)
+	assert.Equal(t, "cache: ignored failure", logger.records[0].msg)
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	var logger = NewStdLogger(log.New(&buf, "", 0))
+
+	logger.Error("cache: operation failed", "store", "redis", "op", "get")
+	assert.Equal(t, "ERROR cache: operation failed store=redis op=get\n", buf.String())
+}
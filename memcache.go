@@ -1,14 +1,22 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
-	"github.com/vmihailenco/msgpack/v5"
 )
 
 type MemcacheStore struct {
+	// client serves reads and writer writes, they differ when the timeouts do
 	client            *memcache.Client
+	writer            *memcache.Client
+	allowFlushAll     bool
+	log               storeLogger
+	codec             Codec
 	DefaultExpiration time.Duration
 }
 
@@ -16,25 +24,101 @@ type MemcacheStoreOptions struct {
 	Servers           []string
 	DefaultExpiration time.Duration
 	MaxIdleConns      int
-	Timeout           time.Duration
+	// Timeout is the socket timeout of the operations not bounded by Timeouts.
+	// Default is 100 milliseconds.
+	Timeout time.Duration
+
+	// AllowFlushAll lets Clear run flush_all, which wipes every key on the servers.
+	// Default is to refuse clearing.
+	AllowFlushAll bool
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes and failures
+	Events *Events
+
+	// Timeouts bound reads and writes, default is Timeout.
+	// Operations running out of time return ErrTimeout.
+	Timeouts Timeouts
 }
 
-func NewMemcacheStore(options *MemcacheStoreOptions) *MemcacheStore {
-	if len(options.Servers) == 0 {
-		panic(ErrMemcacheServerRequired)
+// NewMemcacheStore panics on invalid options, use OpenMemcacheStore to handle them
+func NewMemcacheStore(options *MemcacheStoreOptions, opts ...Option) *MemcacheStore {
+	store, err := OpenMemcacheStore(options, opts...)
+	if err != nil {
+		panic(err)
 	}
+	return store
+}
 
-	var client = memcache.New(options.Servers...)
-	if options.MaxIdleConns > 0 {
-		client.MaxIdleConns = options.MaxIdleConns
+// OpenMemcacheStore validates options and creates the store, it returns
+// ErrMemcacheServerRequired without servers. Connections are lazy, use Ping
+// to check the servers are reachable
+func OpenMemcacheStore(options *MemcacheStoreOptions, opts ...Option) (*MemcacheStore, error) {
+	if options == nil || len(options.Servers) == 0 {
+		return nil, ErrMemcacheServerRequired
 	}
+
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+		Timeouts:          options.Timeouts,
+	}.apply("memcache", opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case options.Timeout < 0:
+		return nil, invalidOptions("memcache", "Timeout must not be negative, got %v", options.Timeout)
+	case options.MaxIdleConns < 0:
+		return nil, invalidOptions("memcache", "MaxIdleConns must not be negative, got %d", options.MaxIdleConns)
+	}
+	if err := new(memcache.ServerList).SetServers(options.Servers...); err != nil {
+		return nil, invalidOptions("memcache", "Servers: %v", err)
+	}
+
+	var timeout = memcache.DefaultTimeout
 	if options.Timeout > 0 {
-		client.Timeout = options.Timeout
+		timeout = options.Timeout
+	}
+	var timeouts = config.Timeouts.orDefault(Timeouts{Read: timeout, Write: timeout})
+
+	// gomemcache only has a client wide timeout, writes get their own client when it differs
+	var client = newMemcacheClient(options, timeouts.Read)
+	var writer = client
+	if timeouts.Write != timeouts.Read {
+		writer = newMemcacheClient(options, timeouts.Write)
 	}
-	return &MemcacheStore{
+
+	var store = &MemcacheStore{
 		client:            client,
-		DefaultExpiration: options.DefaultExpiration,
+		writer:            writer,
+		allowFlushAll:     options.AllowFlushAll,
+		log:               newStoreLogger("memcache", config.Logger, config.SlowThreshold, config.Events),
+		codec:             config.Codec,
+		DefaultExpiration: config.DefaultExpiration,
+	}
+	store.log.timedOut = isMemcacheTimeout
+	return store, nil
+}
+
+func newMemcacheClient(options *MemcacheStoreOptions, timeout time.Duration) *memcache.Client {
+	var client = memcache.New(options.Servers...)
+	if options.MaxIdleConns > 0 {
+		client.MaxIdleConns = options.MaxIdleConns
 	}
+	client.Timeout = timeout
+	return client
+}
+
+// isMemcacheTimeout also recognizes connect timeouts, gomemcache does not report them as net errors
+func isMemcacheTimeout(err error) bool {
+	var connectErr *memcache.ConnectTimeoutError
+	return isTimeout(err) || errors.As(err, &connectErr)
 }
 
 func (c *MemcacheStore) Get(key string, value interface{}) error {
@@ -42,52 +126,387 @@ func (c *MemcacheStore) Get(key string, value interface{}) error {
 		return ErrMustBePointer
 	}
 
-	val, err := c.client.Get(key)
+	bytes, err := c.GetBytes(key)
 	if err != nil {
 		return err
 	}
 
-	err = msgpack.Unmarshal(val.Value, value)
+	err = c.codec.Unmarshal(decodeCounter(c.codec, bytes), value)
 	if err != nil {
+		c.log.failed("get", key, err)
 		return ErrUnmarshal
 	}
 	return nil
 }
 
-func (c *MemcacheStore) Set(key string, value interface{}, expiration ...time.Duration) error {
-	if !isPtr(value) {
-		return ErrMustBePointer
+func (c *MemcacheStore) GetBytes(key string) (_ []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
+	val, err := c.client.Get(key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return val.Value, nil
+}
+
+func (c *MemcacheStore) Set(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	item, err := c.newItem(key, value, expiration...)
+	if err != nil {
+		return err
+	}
+
+	err = c.writer.Set(item)
+	if err != nil {
+		return err
 	}
+	return nil
+}
+
+// SetBytes stores bytes as is, bypassing the codec
+func (c *MemcacheStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	return c.writer.Set(c.newRawItem(key, bytes, expiration...))
+}
 
-	cacheEntry, err := msgpack.Marshal(value)
+func (c *MemcacheStore) Delete(key string) (err error) {
+	defer c.log.track("delete", key, time.Now(), &err)
+
+	err = c.writer.Delete(key)
+	if err != nil && err != memcache.ErrCacheMiss {
+		return err
+	}
+	return nil
+}
+
+func (c *MemcacheStore) Exists(key string) (_ bool, err error) {
+	defer c.log.track("exists", key, time.Now(), &err)
+
+	_, err = c.client.Get(key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// TTL is derived from the expiry tracked in the item flags, items written
+// without it are reported as never expiring
+func (c *MemcacheStore) TTL(key string) (_ time.Duration, err error) {
+	defer c.log.track("ttl", key, time.Now(), &err)
+
+	val, err := c.client.Get(key)
 	if err != nil {
-		return ErrMarshal
+		if err == memcache.ErrCacheMiss {
+			return 0, ErrKeyNotFound
+		}
+		return 0, err
+	}
+
+	if val.Flags == 0 {
+		return NoExpiration, nil
 	}
+
+	return time.Until(time.Unix(int64(val.Flags), 0)), nil
+}
+
+// memcacheTouchAttempts bounds the CompareAndSwap retries of Touch under contention
+const memcacheTouchAttempts = 10
+
+// Touch rewrites the item with compare-and-swap so the expiry tracked in the
+// item flags follows the new lifetime, the touch command cannot change flags.
+// It returns ErrCASConflict when the key keeps changing
+func (c *MemcacheStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	for attempt := 0; attempt < memcacheTouchAttempts; attempt++ {
+		item, err := c.writer.Get(key)
+		if err != nil {
+			if err == memcache.ErrCacheMiss {
+				return ErrKeyNotFound
+			}
+			return err
+		}
+
+		item.Expiration = memcacheExpiration(ttl)
+		item.Flags = memcacheExpiredAt(ttl)
+		err = c.writer.CompareAndSwap(item)
+		switch err {
+		case nil:
+			return nil
+		case memcache.ErrCASConflict:
+			// Written concurrently, touch the new value
+			continue
+		case memcache.ErrNotStored, memcache.ErrCacheMiss:
+			return ErrKeyNotFound
+		}
+		return err
+	}
+	return ErrCASConflict
+}
+
+// Clear runs flush_all on every server, only when AllowFlushAll is set
+func (c *MemcacheStore) Clear() (err error) {
+	defer c.log.track("clear", "", time.Now(), &err)
+
+	if !c.allowFlushAll {
+		return ErrClearNotAllowed
+	}
+	return c.writer.FlushAll()
+}
+
+// Scan is not supported, memcached has no way to list keys
+func (c *MemcacheStore) Scan(ctx context.Context, pattern string, cursor string, count int) (_ []string, _ string, err error) {
+	defer c.log.track("scan", pattern, time.Now(), &err)
+
+	return nil, "", ErrNotSupported
+}
+
+// Increment uses incr/decr, creating the counter with add when it is missing.
+// Memcached counters are unsigned, decrementing stops at zero
+func (c *MemcacheStore) Increment(key string, delta int64, expiration ...time.Duration) (_ int64, err error) {
+	defer c.log.track("increment", key, time.Now(), &err)
+
 	var exp = c.DefaultExpiration
 	if len(expiration) > 0 {
 		exp = expiration[0]
 	}
 
-	var item = memcache.Item{
-		Key:        key,
-		Expiration: int32(exp.Seconds()),
-		Value:      cacheEntry,
+	for {
+		var value uint64
+		var err error
+		if delta < 0 {
+			value, err = c.writer.Decrement(key, uint64(-delta))
+		} else {
+			value, err = c.writer.Increment(key, uint64(delta))
+		}
+		if err == nil {
+			return int64(value), nil
+		}
+		if strings.Contains(err.Error(), "non-numeric") {
+			return 0, ErrNotCounter
+		}
+		if err != memcache.ErrCacheMiss {
+			return 0, err
+		}
+
+		var initial int64
+		if delta > 0 {
+			initial = delta
+		}
+		err = c.writer.Add(&memcache.Item{
+			Key:        key,
+			Expiration: memcacheExpiration(exp),
+			Flags:      memcacheExpiredAt(exp),
+			Value:      []byte(strconv.FormatInt(initial, 10)),
+		})
+		if err == nil {
+			return initial, nil
+		}
+		// Created concurrently, increment the existing counter
+		if err != memcache.ErrNotStored {
+			return 0, err
+		}
+	}
+}
+
+func (c *MemcacheStore) Decrement(key string, delta int64, expiration ...time.Duration) (int64, error) {
+	return c.Increment(key, -delta, expiration...)
+}
+
+func (c *MemcacheStore) Add(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("add", key, time.Now(), &err)
+
+	item, err := c.newItem(key, value, expiration...)
+	if err != nil {
+		return err
+	}
+
+	err = c.writer.Add(item)
+	if err != nil {
+		if err == memcache.ErrNotStored {
+			return ErrNotStored
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *MemcacheStore) Replace(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("replace", key, time.Now(), &err)
+
+	item, err := c.newItem(key, value, expiration...)
+	if err != nil {
+		return err
+	}
+
+	err = c.writer.Replace(item)
+	if err != nil {
+		if err == memcache.ErrNotStored {
+			return ErrNotStored
+		}
+		return err
+	}
+	return nil
+}
+
+// GetCAS returns the memcache item, which carries the CAS unique, as token
+func (c *MemcacheStore) GetCAS(key string, value interface{}) (_ *CASToken, err error) {
+	defer c.log.track("get_cas", key, time.Now(), &err)
+
+	if !isPtr(value) {
+		return nil, ErrMustBePointer
+	}
+
+	val, err := c.client.Get(key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+
+	err = c.codec.Unmarshal(decodeCounter(c.codec, val.Value), value)
+	if err != nil {
+		return nil, ErrUnmarshal
+	}
+	return &CASToken{value: val}, nil
+}
+
+func (c *MemcacheStore) CompareAndSwap(key string, value interface{}, token *CASToken, expiration ...time.Duration) (err error) {
+	defer c.log.track("compare_and_swap", key, time.Now(), &err)
+
+	old, ok := token.version().(*memcache.Item)
+	if !ok || old.Key != key {
+		return ErrCASConflict
+	}
+
+	item, err := c.newItem(key, value, expiration...)
+	if err != nil {
+		return err
 	}
-	err = c.client.Set(&item)
+
+	var swap = *old
+	swap.Value = item.Value
+	swap.Expiration = item.Expiration
+	swap.Flags = item.Flags
+
+	err = c.writer.CompareAndSwap(&swap)
 	if err != nil {
+		if err == memcache.ErrCASConflict || err == memcache.ErrNotStored || err == memcache.ErrCacheMiss {
+			return ErrCASConflict
+		}
 		return err
 	}
 	return nil
 }
 
-func (c *MemcacheStore) Delete(key string) error {
-	var err = c.client.Delete(key)
+// CompareAndDelete swaps the item for one with a negative expiration, which
+// memcached treats as immediately expired, there is no conditional delete command
+func (c *MemcacheStore) CompareAndDelete(key string, token *CASToken) (err error) {
+	defer c.log.track("compare_and_delete", key, time.Now(), &err)
+
+	old, ok := token.version().(*memcache.Item)
+	if !ok || old.Key != key {
+		return ErrCASConflict
+	}
+
+	var swap = *old
+	swap.Expiration = -1
+
+	err = c.writer.CompareAndSwap(&swap)
 	if err != nil {
+		if err == memcache.ErrCASConflict || err == memcache.ErrNotStored || err == memcache.ErrCacheMiss {
+			return ErrCASConflict
+		}
 		return err
 	}
 	return nil
 }
 
+// newItem encodes value into an item expiring after expiration or the store
+// default, values that would read as a counter are encoded again
+func (c *MemcacheStore) newItem(key string, value interface{}, expiration ...time.Duration) (*memcache.Item, error) {
+	if !isPtr(value) {
+		return nil, ErrMustBePointer
+	}
+
+	cacheEntry, err := c.codec.Marshal(value)
+	if err != nil {
+		return nil, ErrMarshal
+	}
+
+	return c.newRawItem(key, encodeNonCounter(c.codec, cacheEntry), expiration...), nil
+}
+
+func (c *MemcacheStore) newRawItem(key string, bytes []byte, expiration ...time.Duration) *memcache.Item {
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	var item = &memcache.Item{
+		Key:        key,
+		Expiration: memcacheExpiration(exp),
+		Flags:      memcacheExpiredAt(exp),
+		Value:      bytes,
+	}
+	return item
+}
+
+// Ping checks every server with a version command
+func (c *MemcacheStore) Ping(ctx context.Context) (err error) {
+	defer c.log.track("ping", "", time.Now(), &err)
+
+	return c.client.Ping()
+}
+
+// Close is a no-op, gomemcache starts no goroutines and cannot close its idle
+// connections, they are released once the store is garbage collected
+func (c *MemcacheStore) Close() error {
+	return nil
+}
+
+func (c *MemcacheStore) decimalCounters() {}
+
+// Codec returns the codec of values
+func (c *MemcacheStore) Codec() Codec {
+	return c.codec
+}
+
 func (c *MemcacheStore) Type() string {
 	return "memcache"
 }
+
+// memcacheRelativeLimit is the largest expiration memcached treats as relative,
+// anything above is read as an absolute unix timestamp
+const memcacheRelativeLimit = 30 * 24 * time.Hour
+
+func memcacheExpiration(exp time.Duration) int32 {
+	if exp <= 0 {
+		return 0
+	}
+	if exp > memcacheRelativeLimit {
+		return int32(time.Now().Add(exp).Unix())
+	}
+	if exp < time.Second {
+		return 1
+	}
+	return int32(exp.Seconds())
+}
+
+// memcacheExpiredAt is stored in the item flags to track the expiry, which
+// memcached itself does not expose
+func memcacheExpiredAt(exp time.Duration) uint32 {
+	if exp <= 0 {
+		return 0
+	}
+	return uint32(time.Now().Add(exp).Unix())
+}
@@ -1,21 +1,44 @@
 package cache
 
 import (
+	"context"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/patrickmn/go-cache"
-	"github.com/vmihailenco/msgpack/v5"
 )
 
 type MemoryStore struct {
-	client            *cache.Cache
+	client *cache.Cache
+	// mu serializes the writes, which number each key in versions for CompareAndSwap
+	mu       sync.Mutex
+	versions map[string]uint64
+	sequence uint64
+	// evicted holds the keys go-cache evicts under mu, fired once it is released
+	evicted []string
+
+	scans             scanSnapshots
+	log               storeLogger
+	stop              chan struct{}
+	closeOnce         sync.Once
+	codec             Codec
 	DefaultExpiration time.Duration
 }
 
 type MemoryStoreOptions struct {
 	DefaultExpiration time.Duration
 	DefaultCacheItems map[string]cache.Item
-	CleanupInterval   time.Duration
+	// CleanupInterval is the period of the removal of expired keys, 0 or a
+	// negative value disables it
+	CleanupInterval time.Duration
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes, evictions and failures
+	Events *Events
 }
 
 var MemoryStoreOptionsDefault = &MemoryStoreOptions{
@@ -23,17 +46,50 @@ var MemoryStoreOptionsDefault = &MemoryStoreOptions{
 	CleanupInterval:   time.Hour * 26,
 }
 
-func NewMemoryStore(options MemoryStoreOptions) *MemoryStore {
+// NewMemoryStore panics on invalid options, use OpenMemoryStore to handle them
+func NewMemoryStore(options MemoryStoreOptions, opts ...Option) *MemoryStore {
+	store, err := OpenMemoryStore(options, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// OpenMemoryStore validates options and creates the store
+func OpenMemoryStore(options MemoryStoreOptions, opts ...Option) (*MemoryStore, error) {
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+	}.apply("memory", opts)
+	if err != nil {
+		return nil, err
+	}
 	var items = make(map[string]cache.Item)
 	if options.DefaultCacheItems != nil {
 		items = options.DefaultCacheItems
 	}
 
-	var client = cache.NewFrom(options.DefaultExpiration, options.CleanupInterval, items)
-	return &MemoryStore{
-		client:            client,
-		DefaultExpiration: options.DefaultExpiration,
+	var store = &MemoryStore{
+		client:            cache.NewFrom(config.DefaultExpiration, 0, items),
+		versions:          make(map[string]uint64),
+		log:               newStoreLogger("memory", config.Logger, config.SlowThreshold, config.Events),
+		stop:              make(chan struct{}),
+		codec:             config.Codec,
+		DefaultExpiration: config.DefaultExpiration,
 	}
+
+	// go-cache calls OnEvicted for expired items removed by the janitor and for
+	// Delete, both run under mu
+	store.client.OnEvicted(func(key string, _ interface{}) {
+		store.evicted = append(store.evicted, key)
+	})
+	if options.CleanupInterval > 0 {
+		go store.janitor(options.CleanupInterval)
+	}
+
+	return store, nil
 }
 
 func (c *MemoryStore) Get(key string, value interface{}) error {
@@ -41,46 +97,383 @@ func (c *MemoryStore) Get(key string, value interface{}) error {
 		return ErrMustBePointer
 	}
 
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+// GetBytes returns a copy of the stored bytes, counters are returned encoded with the codec
+func (c *MemoryStore) GetBytes(key string) (_ []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
 	val, found := c.client.Get(key)
 	if !found {
-		return ErrKeyNotFound
+		return nil, ErrKeyNotFound
 	}
+	return c.bytes(val)
+}
 
-	var err error
+// bytes returns a copy of a stored value, a counter is encoded with the codec
+func (c *MemoryStore) bytes(val interface{}) ([]byte, error) {
 	switch v := val.(type) {
 	case []byte:
-		err = msgpack.Unmarshal(v, value)
+		return append([]byte(nil), v...), nil
 	case string:
-		err = msgpack.Unmarshal([]byte(v), value)
+		return []byte(v), nil
+	case int64:
+		return c.codec.Marshal(v)
+	}
+
+	return nil, ErrUnmarshal
+}
 
+func (c *MemoryStore) Set(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	bytes, exp, err := c.encode(value, expiration...)
+	if err != nil {
+		return err
 	}
 
-	return err
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.client.Set(key, bytes, exp)
+	c.written(key)
+	return nil
+}
+
+// SetBytes stores a copy of bytes as is, bypassing the codec
+func (c *MemoryStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	var exp = c.expiration(expiration...)
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.client.Set(key, append([]byte(nil), bytes...), exp)
+	c.written(key)
+	return nil
 }
 
-func (c *MemoryStore) Set(key string, value interface{}, expiration ...time.Duration) error {
+// encode marshals value and resolves its expiration
+func (c *MemoryStore) encode(value interface{}, expiration ...time.Duration) ([]byte, time.Duration, error) {
 	if !isPtr(value) {
-		return ErrMustBePointer
+		return nil, 0, ErrMustBePointer
+	}
+
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return nil, 0, err
 	}
+	return bytes, c.expiration(expiration...), nil
+}
 
+// expiration resolves the expiration of a write for go-cache, which takes 0 as
+// its default expiration rather than NoExpiration
+func (c *MemoryStore) expiration(expiration ...time.Duration) time.Duration {
 	var exp = c.DefaultExpiration
 	if len(expiration) > 0 {
 		exp = expiration[0]
 	}
 
-	bytes, err := msgpack.Marshal(value)
+	if exp <= 0 {
+		return cache.NoExpiration
+	}
+	return exp
+}
+
+func (c *MemoryStore) Delete(key string) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.client.Delete(key)
+	delete(c.versions, key)
+	return nil
+}
+
+func (c *MemoryStore) Exists(key string) (bool, error) {
+	_, found := c.client.Get(key)
+	return found, nil
+}
+
+func (c *MemoryStore) TTL(key string) (time.Duration, error) {
+	_, expiredAt, found := c.client.GetWithExpiration(key)
+	if !found {
+		return 0, ErrKeyNotFound
+	}
+
+	if expiredAt.IsZero() {
+		return NoExpiration, nil
+	}
+
+	return time.Until(expiredAt), nil
+}
+
+// Touch re-sets the stored bytes with the new lifetime, the value is not
+// re-encoded and keeps its CAS version
+func (c *MemoryStore) Touch(key string, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	val, found := c.client.Get(key)
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	if ttl <= 0 {
+		ttl = cache.NoExpiration
+	}
+	c.client.Set(key, val, ttl)
+	return nil
+}
+
+func (c *MemoryStore) Clear() error {
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.client.Flush()
+	c.versions = make(map[string]uint64)
+	return nil
+}
+
+// Scan pages through a sorted snapshot of the keys taken on the first page,
+// the cursor is the last key returned
+func (c *MemoryStore) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var list = func() []string {
+		var keys []string
+		for key := range c.client.Items() {
+			if re.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	var alive = func(key string) bool {
+		_, found := c.client.Get(key)
+		return found
+	}
+
+	keys, next := c.scans.page(pattern, cursor, scanCount(count), list, alive)
+	return keys, next, nil
+}
+
+// Increment adds delta to the counter under the store mutex
+func (c *MemoryStore) Increment(key string, delta int64, expiration ...time.Duration) (_ int64, err error) {
+	defer c.log.track("increment", key, time.Now(), &err)
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	val, found := c.client.Get(key)
+	if !found {
+		c.client.Set(key, delta, c.expiration(expiration...))
+		c.written(key)
+		return delta, nil
+	}
+
+	if _, ok := val.(int64); !ok {
+		return 0, ErrNotCounter
+	}
+	c.written(key)
+	return c.client.IncrementInt64(key, delta)
+}
+
+func (c *MemoryStore) Decrement(key string, delta int64, expiration ...time.Duration) (int64, error) {
+	return c.Increment(key, -delta, expiration...)
+}
+
+func (c *MemoryStore) Add(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("add", key, time.Now(), &err)
+
+	bytes, exp, err := c.encode(value, expiration...)
 	if err != nil {
 		return err
 	}
-	c.client.Set(key, bytes, exp)
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	if err := c.client.Add(key, bytes, exp); err != nil {
+		return ErrNotStored
+	}
+	c.written(key)
 	return nil
 }
 
-func (c *MemoryStore) Delete(key string) error {
+func (c *MemoryStore) Replace(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("replace", key, time.Now(), &err)
+
+	bytes, exp, err := c.encode(value, expiration...)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	if err := c.client.Replace(key, bytes, exp); err != nil {
+		return ErrNotStored
+	}
+	c.written(key)
+	return nil
+}
+
+// GetCAS returns the version of the key as token, every write numbers it
+// so a value written back after a change does not match
+func (c *MemoryStore) GetCAS(key string, value interface{}) (*CASToken, error) {
+	if !isPtr(value) {
+		return nil, ErrMustBePointer
+	}
+
+	c.mu.Lock()
+	val, found := c.client.Get(key)
+	var version = c.versions[key]
+	c.unlock()
+
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+
+	// Counters read like int64 values, as they do from Get
+	bytes, err := c.bytes(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		return nil, ErrUnmarshal
+	}
+	return &CASToken{value: version}, nil
+}
+
+// CompareAndSwap is atomic with respect to every other write on the store
+func (c *MemoryStore) CompareAndSwap(key string, value interface{}, token *CASToken, expiration ...time.Duration) (err error) {
+	defer c.log.track("compare_and_swap", key, time.Now(), &err)
+
+	version, ok := token.version().(uint64)
+	if !ok {
+		return ErrCASConflict
+	}
+
+	entry, exp, err := c.encode(value, expiration...)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	if !c.unchanged(key, version) {
+		return ErrCASConflict
+	}
+
+	c.client.Set(key, entry, exp)
+	c.written(key)
+	return nil
+}
+
+func (c *MemoryStore) CompareAndDelete(key string, token *CASToken) error {
+	version, ok := token.version().(uint64)
+	if !ok {
+		return ErrCASConflict
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	if !c.unchanged(key, version) {
+		return ErrCASConflict
+	}
+
 	c.client.Delete(key)
+	delete(c.versions, key)
 	return nil
 }
 
+// written gives key a new version, mu must be held
+func (c *MemoryStore) written(key string) {
+	c.sequence++
+	c.versions[key] = c.sequence
+}
+
+// unchanged reports whether key is present at version, mu must be held
+func (c *MemoryStore) unchanged(key string, version uint64) bool {
+	_, found := c.client.Get(key)
+	return found && c.versions[key] == version
+}
+
+// unlock releases mu and fires the evictions that happened while it was held
+func (c *MemoryStore) unlock() {
+	var evicted = c.evicted
+	c.evicted = nil
+	c.mu.Unlock()
+
+	for _, key := range evicted {
+		c.log.events.fireEvict(key)
+	}
+}
+
+// Ping always succeeds, the store is in process
+func (c *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close stops the janitor removing expired items
+func (c *MemoryStore) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	return nil
+}
+
+// janitor removes expired items every interval until the store is closed,
+// it replaces the go-cache janitor which can only be stopped by the garbage collector
+func (c *MemoryStore) janitor(interval time.Duration) {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Codec returns the codec of values
+func (c *MemoryStore) Codec() Codec {
+	return c.codec
+}
+
 func (c *MemoryStore) Type() string {
 	return "memory"
 }
+
+// deleteExpired removes expired items and the versions of the keys that are gone
+func (c *MemoryStore) deleteExpired() {
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.client.DeleteExpired()
+	for key := range c.versions {
+		if _, found := c.client.Get(key); !found {
+			delete(c.versions, key)
+		}
+	}
+}
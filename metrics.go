@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Operation results reported to Metrics
+const (
+	ResultHit   = "hit"
+	ResultMiss  = "miss"
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+// Operation is a measurement of a single cache call
+type Operation struct {
+	Store     string
+	Namespace string
+	// Name is the method called: get, set, delete, get_bytes, ...
+	Name string
+	// Result is ResultHit or ResultMiss for reads, ResultOK otherwise, or ResultError
+	Result   string
+	Duration time.Duration
+	// Size is the payload size in bytes, -1 on misses, failures and operations without one
+	Size int
+}
+
+// Metrics receives the measurements of an Instrumented cache
+type Metrics interface {
+	Observe(op Operation)
+}
+
+// Instrumented reports every operation of the wrapped cache to Metrics,
+// labelled by the store Type() and a namespace. Get and Set of a single store
+// go through GetBytes and SetBytes so their payload size is measured, over a
+// Chain or another wrapper they record no size
+type Instrumented struct {
+	Cache
+	metrics   Metrics
+	namespace string
+}
+
+func NewInstrumented(cache Cache, metrics Metrics, namespace string) *Instrumented {
+	return &Instrumented{
+		Cache:     cache,
+		metrics:   metrics,
+		namespace: namespace,
+	}
+}
+
+// Unwrap returns the wrapped cache
+func (c *Instrumented) Unwrap() Cache {
+	return c.Cache
+}
+
+func (c *Instrumented) Get(key string, value interface{}) error {
+	var start = time.Now()
+	size, err := getValue(c.Cache, key, value)
+	c.observe("get", readResult(err), start, size)
+	return err
+}
+
+func (c *Instrumented) Set(key string, value interface{}, expiration ...time.Duration) error {
+	var start = time.Now()
+	size, err := setValue(c.Cache, key, value, expiration...)
+	c.observe("set", writeResult(err), start, size)
+	return err
+}
+
+func (c *Instrumented) Delete(key string) error {
+	var start = time.Now()
+	var err = c.Cache.Delete(key)
+	c.observe("delete", writeResult(err), start, -1)
+	return err
+}
+
+func (c *Instrumented) GetBytes(key string) ([]byte, error) {
+	var start = time.Now()
+	bytes, err := c.Cache.GetBytes(key)
+	c.observe("get_bytes", readResult(err), start, payloadSize(bytes, err))
+	return bytes, err
+}
+
+func (c *Instrumented) SetBytes(key string, bytes []byte, expiration ...time.Duration) error {
+	var start = time.Now()
+	var err = c.Cache.SetBytes(key, bytes, expiration...)
+	c.observe("set_bytes", writeResult(err), start, payloadSize(bytes, err))
+	return err
+}
+
+func (c *Instrumented) Exists(key string) (bool, error) {
+	var start = time.Now()
+	found, err := c.Cache.Exists(key)
+	var result = ResultMiss
+	if err != nil {
+		result = ResultError
+	} else if found {
+		result = ResultHit
+	}
+	c.observe("exists", result, start, -1)
+	return found, err
+}
+
+func (c *Instrumented) TTL(key string) (time.Duration, error) {
+	var start = time.Now()
+	ttl, err := c.Cache.TTL(key)
+	c.observe("ttl", readResult(err), start, -1)
+	return ttl, err
+}
+
+func (c *Instrumented) Touch(key string, ttl time.Duration) error {
+	var start = time.Now()
+	var err = c.Cache.Touch(key, ttl)
+	c.observe("touch", readResult(err), start, -1)
+	return err
+}
+
+func (c *Instrumented) Clear() error {
+	var start = time.Now()
+	var err = c.Cache.Clear()
+	c.observe("clear", writeResult(err), start, -1)
+	return err
+}
+
+func (c *Instrumented) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	var start = time.Now()
+	keys, next, err := c.Cache.Scan(ctx, pattern, cursor, count)
+	c.observe("scan", writeResult(err), start, -1)
+	return keys, next, err
+}
+
+func (c *Instrumented) observe(name string, result string, start time.Time, size int) {
+	c.metrics.Observe(Operation{
+		Store:     c.Cache.Type(),
+		Namespace: c.namespace,
+		Name:      name,
+		Result:    result,
+		Duration:  time.Since(start),
+		Size:      size,
+	})
+}
+
+// payloadSize is the size of bytes read or written, -1 on a miss or a failure
+func payloadSize(bytes []byte, err error) int {
+	if err != nil {
+		return -1
+	}
+	return len(bytes)
+}
+
+func readResult(err error) string {
+	switch err {
+	case nil:
+		return ResultHit
+	case ErrKeyNotFound:
+		return ResultMiss
+	}
+	return ResultError
+}
+
+func writeResult(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultOK
+}
+
+// MemoryMetrics keeps measurements in memory, for tests and debugging
+type MemoryMetrics struct {
+	mu         sync.Mutex
+	operations []Operation
+}
+
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{}
+}
+
+func (m *MemoryMetrics) Observe(op Operation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operations = append(m.operations, op)
+}
+
+// Count returns the number of operations with the given name and result
+func (m *MemoryMetrics) Count(name string, result string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int
+	for _, op := range m.operations {
+		if op.Name == name && op.Result == result {
+			count++
+		}
+	}
+	return count
+}
+
+// Operations returns a copy of every recorded operation
+func (m *MemoryMetrics) Operations() []Operation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Operation(nil), m.operations...)
+}
+
+// Reset drops the recorded operations
+func (m *MemoryMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.operations = nil
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumented(t *testing.T) {
+	var metrics = NewMemoryMetrics()
+	var instrumented Cache = NewInstrumented(NewMemoryStore(MemoryStoreOptions{}), metrics, "sessions")
+
+	var strIn = "Hello world"
+	assert.NoError(t, instrumented.Set("test_metrics", &strIn))
+
+	var strOut string
+	assert.NoError(t, instrumented.Get("test_metrics", &strOut))
+	assert.Equal(t, ErrKeyNotFound, instrumented.Get("test_metrics_missing", &strOut))
+	assert.Equal(t, ErrMustBePointer, instrumented.Get("test_metrics", strOut))
+	assert.NoError(t, instrumented.SetBytes("test_metrics_raw", []byte("raw")))
+	_, err := instrumented.GetBytes("test_metrics_missing")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Equal(t, 1, metrics.Count("set", ResultOK))
+	assert.Equal(t, 1, metrics.Count("get", ResultHit))
+	assert.Equal(t, 1, metrics.Count("get", ResultMiss))
+	assert.Equal(t, 1, metrics.Count("get", ResultError))
+
+	var ops = metrics.Operations()
+	assert.Equal(t, "memory", ops[0].Store)
+	assert.Equal(t, "sessions", ops[0].Namespace)
+	var encoded, _ = DefaultCodec.Marshal(&strIn)
+	assert.Equal(t, len(encoded), ops[0].Size)
+	assert.Equal(t, len(encoded), ops[1].Size)
+	assert.Equal(t, -1, ops[2].Size)
+	assert.Equal(t, 3, ops[len(ops)-2].Size)
+	assert.Equal(t, -1, ops[len(ops)-1].Size)
+
+	// The size is not measured over a wrapper
+	metrics = NewMemoryMetrics()
+	instrumented = NewInstrumented(Wrap(NewMemoryStore(MemoryStoreOptions{})), metrics, "sessions")
+	assert.NoError(t, instrumented.Set("test_metrics", &strIn))
+	assert.Equal(t, -1, metrics.Operations()[0].Size)
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	var metrics = NewPrometheusMetrics(PrometheusMetricsOptions{Namespace: "test"})
+	var registry = prometheus.NewRegistry()
+	assert.NoError(t, registry.Register(metrics))
+
+	var instrumented Cache = NewInstrumented(NewMemoryStore(MemoryStoreOptions{}), metrics, "sessions")
+	var strOut string
+	instrumented.Get("test_metrics_missing", &strOut)
+
+	var expected = `
+# HELP test_cache_operations_total Cache operations by store, namespace, operation and result (hit, miss, ok, error).
+# TYPE test_cache_operations_total counter
+test_cache_operations_total{namespace="sessions",operation="get",result="miss",store="memory"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "test_cache_operations_total"))
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"time"
+)
+
+// Middleware intercepts Get, Set and Delete of a wrapped cache. A Before hook
+// returning an error aborts the call with that error. After hooks receive the
+// result of the call and return the error passed back to the caller, so they
+// can observe, translate or swallow it. Nil hooks are skipped
+type Middleware struct {
+	BeforeGet func(key string) error
+	AfterGet  func(key string, value interface{}, err error) error
+
+	BeforeSet func(key string, value interface{}) error
+	AfterSet  func(key string, value interface{}, err error) error
+
+	BeforeDelete func(key string) error
+	AfterDelete  func(key string, err error) error
+}
+
+// Wrapped runs middleware around a cache. Before hooks run in the order given
+// to Wrap and After hooks in reverse, only the middleware whose Before hook
+// passed see the After hook. The other methods, including GetBytes and
+// SetBytes, go to the cache untouched
+type Wrapped struct {
+	Cache
+	middleware []Middleware
+}
+
+func Wrap(cache Cache, middleware ...Middleware) *Wrapped {
+	return &Wrapped{
+		Cache:      cache,
+		middleware: middleware,
+	}
+}
+
+// Unwrap returns the wrapped cache
+func (c *Wrapped) Unwrap() Cache {
+	return c.Cache
+}
+
+func (c *Wrapped) Get(key string, value interface{}) error {
+	n, err := c.before(func(m Middleware) error {
+		if m.BeforeGet == nil {
+			return nil
+		}
+		return m.BeforeGet(key)
+	})
+	if err == nil {
+		err = c.Cache.Get(key, value)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		if after := c.middleware[i].AfterGet; after != nil {
+			err = after(key, value, err)
+		}
+	}
+	return err
+}
+
+func (c *Wrapped) Set(key string, value interface{}, expiration ...time.Duration) error {
+	n, err := c.before(func(m Middleware) error {
+		if m.BeforeSet == nil {
+			return nil
+		}
+		return m.BeforeSet(key, value)
+	})
+	if err == nil {
+		err = c.Cache.Set(key, value, expiration...)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		if after := c.middleware[i].AfterSet; after != nil {
+			err = after(key, value, err)
+		}
+	}
+	return err
+}
+
+func (c *Wrapped) Delete(key string) error {
+	n, err := c.before(func(m Middleware) error {
+		if m.BeforeDelete == nil {
+			return nil
+		}
+		return m.BeforeDelete(key)
+	})
+	if err == nil {
+		err = c.Cache.Delete(key)
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		if after := c.middleware[i].AfterDelete; after != nil {
+			err = after(key, err)
+		}
+	}
+	return err
+}
+
+// before runs hook for every middleware until one fails, it returns the
+// number of middleware that passed
+func (c *Wrapped) before(hook func(m Middleware) error) (int, error) {
+	for i, m := range c.middleware {
+		if err := hook(m); err != nil {
+			return i, err
+		}
+	}
+	return len(c.middleware), nil
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapMiddleware(t *testing.T) {
+	var store = NewMemoryStore(MemoryStoreOptions{})
+	var calls []string
+	var errReadOnly = errors.New("read only")
+
+	var audit = Middleware{
+		BeforeSet: func(key string, value interface{}) error {
+			calls = append(calls, "audit:before_set")
+			return nil
+		},
+		AfterSet: func(key string, value interface{}, err error) error {
+			calls = append(calls, "audit:after_set")
+			return err
+		},
+		AfterGet: func(key string, value interface{}, err error) error {
+			calls = append(calls, "audit:after_get")
+			return err
+		},
+	}
+	var readOnly = Middleware{
+		BeforeDelete: func(key string) error {
+			return errReadOnly
+		},
+		BeforeSet: func(key string, value interface{}) error {
+			calls = append(calls, "validate:before_set")
+			if *value.(*string) == "" {
+				return ErrNotStored
+			}
+			return nil
+		},
+		AfterSet: func(key string, value interface{}, err error) error {
+			calls = append(calls, "validate:after_set")
+			return err
+		},
+	}
+	var cache = Wrap(store, audit, readOnly)
+
+	var strIn = "Hello world"
+	assert.NoError(t, cache.Set("test_wrap", &strIn))
+	assert.Equal(t, []string{"audit:before_set", "validate:before_set", "validate:after_set", "audit:after_set"}, calls)
+
+	// A failing Before hook aborts the call, only the middleware before it see the result
+	calls = nil
+	var empty string
+	assert.Equal(t, ErrNotStored, cache.Set("test_wrap_empty", &empty))
+	assert.Equal(t, []string{"audit:before_set", "validate:before_set", "audit:after_set"}, calls)
+	found, err := store.Exists("test_wrap_empty")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.Equal(t, errReadOnly, cache.Delete("test_wrap"))
+	found, err = store.Exists("test_wrap")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	// After hooks may swallow errors
+	var strOut string
+	var lenient = Wrap(store, Middleware{
+		AfterGet: func(key string, value interface{}, err error) error {
+			if err == ErrKeyNotFound {
+				return nil
+			}
+			return err
+		},
+	})
+	assert.NoError(t, lenient.Get("test_wrap_missing", &strOut))
+	assert.NoError(t, lenient.Get("test_wrap", &strOut))
+	assert.Equal(t, strIn, strOut)
+}
+
+type recordedEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordedEvents) record(name string) func(key string) {
+	return func(key string) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, name+":"+key)
+	}
+}
+
+func (r *recordedEvents) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *recordedEvents) hooks() *Events {
+	return &Events{
+		OnHit:   r.record("hit"),
+		OnMiss:  r.record("miss"),
+		OnSet:   r.record("set"),
+		OnEvict: r.record("evict"),
+		OnError: func(op string, key string, err error) {
+			r.record("error")(op + ":" + key)
+		},
+	}
+}
+
+func TestMemoryStoreEvents(t *testing.T) {
+	var recorded = &recordedEvents{}
+	var store = NewMemoryStore(MemoryStoreOptions{Events: recorded.hooks()})
+
+	var strIn = "Hello world"
+	var strOut string
+	assert.NoError(t, store.Set("test_events", &strIn))
+	assert.NoError(t, store.Get("test_events", &strOut))
+	assert.Equal(t, ErrKeyNotFound, store.Get("test_events_missing", &strOut))
+	store.client.Set("test_events_float", 3.14, 0)
+	assert.Equal(t, ErrUnmarshal, store.Get("test_events_float", &strOut))
+	var intOut int
+	assert.Error(t, store.Get("test_events", &intOut))
+
+	// Conditional writes and counters are writes too, a failed Add is not
+	assert.NoError(t, store.Add("test_events_add", &strIn))
+	assert.Equal(t, ErrNotStored, store.Add("test_events_add", &strIn))
+	assert.NoError(t, store.Replace("test_events_add", &strIn))
+	token, err := store.GetCAS("test_events_add", &strOut)
+	assert.NoError(t, err)
+	assert.NoError(t, store.CompareAndSwap("test_events_add", &strIn, token))
+	_, err = store.Increment("test_events_counter", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Delete("test_events"))
+
+	assert.Equal(t, []string{
+		"set:test_events",
+		"hit:test_events",
+		"miss:test_events_missing",
+		"error:get:test_events_float",
+		"hit:test_events",
+		"error:get:test_events",
+		"set:test_events_add",
+		"set:test_events_add",
+		"set:test_events_add",
+		"set:test_events_counter",
+		"evict:test_events",
+	}, recorded.list())
+}
+
+func TestRistrettoStoreEvents(t *testing.T) {
+	var recorded = &recordedEvents{}
+	var store = NewRistrettoStore(&RistrettoStoreOptions{
+		NumCounters: 1e4,
+		MaxCost:     1 << 20,
+		BufferItems: 64,
+		DefaultCost: 1,
+		Events:      recorded.hooks(),
+	})
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_events", &strIn))
+	store.client.Wait()
+
+	var strOut string
+	assert.NoError(t, store.Get("test_events", &strOut))
+
+	assert.NoError(t, store.Clear())
+
+	assert.Equal(t, []string{"set:test_events", "hit:test_events", "evict:test_events"}, recorded.list())
+}
@@ -2,12 +2,9 @@ package cache
 
 import (
 	"context"
-	"log"
-	"reflect"
 	"time"
 
 	"github.com/patrickmn/go-cache"
-	"github.com/vmihailenco/msgpack/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -17,12 +14,39 @@ type mongoItem struct {
 	Key       string `bson:"_id"`
 	ExpiredAt int64  `bson:"expired_at"`
 	Value     string `bson:"value"`
+	Counter   *int64 `bson:"counter,omitempty"`
+	Version   int64  `bson:"version"`
 }
+
+// update writes the item value and bumps its version for CompareAndSwap,
+// a counter under the key is replaced
+func (item *mongoItem) update() bson.M {
+	return bson.M{
+		"$set": bson.M{
+			"value":      item.Value,
+			"expired_at": item.ExpiredAt,
+		},
+		"$unset": bson.M{"counter": ""},
+		"$inc":   bson.M{"version": 1},
+	}
+}
+
+// bytes returns the encoded value, counters are encoded with codec
+func (item *mongoItem) bytes(codec Codec) ([]byte, error) {
+	if item.Counter != nil {
+		return codec.Marshal(*item.Counter)
+	}
+	return []byte(item.Value), nil
+}
+
 type MongoDBStore struct {
 	client            *mongo.Client
 	DefaultExpiration time.Duration
 	databaseName      string
 	entity            string
+	timeouts          Timeouts
+	log               storeLogger
+	codec             Codec
 }
 
 type MongoDBStoreOptions struct {
@@ -32,33 +56,79 @@ type MongoDBStoreOptions struct {
 	DefaultExpiration time.Duration
 	DefaultCacheItems map[string]cache.Item
 	CleanupInterval   time.Duration
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes and failures
+	Events *Events
+
+	// Timeouts bound reads and writes, default is TimeoutsDefault.
+	// Operations running out of time return ErrTimeout.
+	Timeouts Timeouts
 }
 
-func NewMongoDBStore(opt MongoDBStoreOptions) *MongoDBStore {
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(opt.DatabaseURI))
+// NewMongoDBStore panics on invalid options or when the server cannot be
+// reached, use OpenMongoDBStore to handle them
+func NewMongoDBStore(opt MongoDBStoreOptions, opts ...Option) *MongoDBStore {
+	store, err := OpenMongoDBStore(opt, opts...)
 	if err != nil {
 		panic(err)
 	}
+	return store
+}
+
+// OpenMongoDBStore validates options, connects and pings the server within the read timeout
+func OpenMongoDBStore(opt MongoDBStoreOptions, opts ...Option) (*MongoDBStore, error) {
+	config, err := storeConfig{
+		DefaultExpiration: opt.DefaultExpiration,
+		Logger:            opt.Logger,
+		SlowThreshold:     opt.SlowThreshold,
+		Events:            opt.Events,
+		Timeouts:          opt.Timeouts,
+	}.apply("mongodb", opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case opt.DatabaseURI == "":
+		return nil, invalidOptions("mongodb", "DatabaseURI is required")
+	case opt.DatabaseName == "":
+		return nil, invalidOptions("mongodb", "DatabaseName is required")
+	}
+
+	var clientOptions = options.Client().ApplyURI(opt.DatabaseURI)
+	if err := clientOptions.Validate(); err != nil {
+		return nil, invalidOptions("mongodb", "DatabaseURI: %v", err)
+	}
+
+	client, err := mongo.Connect(context.Background(), clientOptions)
+	if err != nil {
+		return nil, err
+	}
 
 	var store = &MongoDBStore{
 		client:            client,
-		DefaultExpiration: opt.DefaultExpiration,
+		DefaultExpiration: config.DefaultExpiration,
 		databaseName:      opt.DatabaseName,
 		entity:            opt.Entity,
+		timeouts:          config.Timeouts.orDefault(TimeoutsDefault),
+		log:               newStoreLogger("mongodb", config.Logger, config.SlowThreshold, config.Events),
+		codec:             config.Codec,
 	}
+	store.log.timedOut = mongo.IsTimeout
 
 	if store.entity == "" {
 		store.entity = "caches"
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatalf("Connect to %s %v\n", opt.DatabaseURI, err)
+	if err := store.Ping(context.Background()); err != nil {
+		store.Close()
+		return nil, err
 	}
 
-	return store
+	return store, nil
 }
 
 func (c *MongoDBStore) getCollection() *mongo.Collection {
@@ -71,21 +141,79 @@ func (c *MongoDBStore) Get(key string, value interface{}) error {
 		return ErrMustBePointer
 	}
 
-	var content = mongoItem{}
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	err = c.codec.Unmarshal(bytes, value)
+	if err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+
+	return nil
+}
+
+func (c *MongoDBStore) GetBytes(key string) (_ []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
 	defer cancel()
+
+	content, err := c.findItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return content.bytes(c.codec)
+}
+
+// findItem loads the item for key, expired items are deleted and reported as ErrKeyNotFound
+func (c *MongoDBStore) findItem(ctx context.Context, key string) (*mongoItem, error) {
+	var content = mongoItem{}
 	var query = bson.M{"_id": key}
 	if err := c.getCollection().FindOne(ctx, query).Decode(&content); err != nil {
-		return err
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
 	}
 
-	if content.ExpiredAt <= time.Now().Unix() {
+	if content.ExpiredAt > 0 && content.ExpiredAt <= time.Now().Unix() {
 		if _, err := c.getCollection().DeleteOne(ctx, query); err != nil {
-			return err
+			return nil, err
 		}
+		return nil, ErrKeyNotFound
 	}
 
-	var err = msgpack.Unmarshal([]byte(content.Value), value)
+	return &content, nil
+}
+
+func (c *MongoDBStore) Set(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	content, err := c.newItem(key, value, expiration...)
+	if err != nil {
+		return err
+	}
+
+	return c.upsert(content)
+}
+
+// SetBytes stores bytes as is, bypassing the codec
+func (c *MongoDBStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	return c.upsert(c.newRawItem(key, bytes, expiration...))
+}
+
+func (c *MongoDBStore) upsert(content *mongoItem) error {
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	var query = bson.M{"_id": content.Key}
+	_, err := c.getCollection().UpdateOne(ctx, query, content.update(), options.Update().SetUpsert(true))
 	if err != nil {
 		return err
 	}
@@ -93,64 +221,380 @@ func (c *MongoDBStore) Get(key string, value interface{}) error {
 	return nil
 }
 
-func (c *MongoDBStore) Set(key string, value interface{}, expiration ...time.Duration) error {
-	var v = reflect.ValueOf(value)
-	if v.Kind() != reflect.Ptr {
-		return ErrMustBePointer
+// newItem encodes value into an item expiring after expiration or the store default
+func (c *MongoDBStore) newItem(key string, value interface{}, expiration ...time.Duration) (*mongoItem, error) {
+	if !isPtr(value) {
+		return nil, ErrMustBePointer
+	}
+
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return nil, err
 	}
 
+	return c.newRawItem(key, bytes, expiration...), nil
+}
+
+func (c *MongoDBStore) newRawItem(key string, bytes []byte, expiration ...time.Duration) *mongoItem {
 	var exp = c.DefaultExpiration
 	if len(expiration) > 0 {
 		exp = expiration[0]
 	}
 
-	bytes, err := msgpack.Marshal(value)
+	var content = &mongoItem{
+		Key:   key,
+		Value: string(bytes),
+	}
+
+	if exp > 0 {
+		content.ExpiredAt = time.Now().Add(exp).Unix()
+	}
+
+	return content
+}
+
+func (c *MongoDBStore) Delete(key string) (err error) {
+	defer c.log.track("delete", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+	var query = bson.M{"_id": key}
+	if _, err := c.getCollection().DeleteOne(ctx, query); err != nil {
+		return err
+	}
+	return nil
+}
+
+// aliveQuery matches key, a value or an _id condition, only while it has not expired
+func (c *MongoDBStore) aliveQuery(key interface{}) bson.M {
+	return bson.M{
+		"_id": key,
+		"$or": bson.A{
+			bson.M{"expired_at": 0},
+			bson.M{"expired_at": bson.M{"$gt": time.Now().Unix()}},
+		},
+	}
+}
+
+func (c *MongoDBStore) Exists(key string) (_ bool, err error) {
+	defer c.log.track("exists", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	n, err := c.getCollection().CountDocuments(ctx, c.aliveQuery(key))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c *MongoDBStore) TTL(key string) (_ time.Duration, err error) {
+	defer c.log.track("ttl", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	content, err := c.findItem(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if content.ExpiredAt == 0 {
+		return NoExpiration, nil
+	}
+
+	return time.Until(time.Unix(content.ExpiredAt, 0)), nil
+}
+
+func (c *MongoDBStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	var expiredAt int64
+	if ttl > 0 {
+		expiredAt = time.Now().Add(ttl).Unix()
+	}
+
+	var update = bson.M{
+		"$set": bson.M{"expired_at": expiredAt},
+	}
+	result, err := c.getCollection().UpdateOne(ctx, c.aliveQuery(key), update)
 	if err != nil {
 		return err
 	}
+	if result.MatchedCount == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
 
-	var content = mongoItem{
-		Key:   key,
-		Value: string(bytes),
+// Clear deletes every item in the store's collection, indexes are kept
+func (c *MongoDBStore) Clear() (err error) {
+	defer c.log.track("clear", "", time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	if _, err := c.getCollection().DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Scan matches _id against the pattern in _id order, the cursor is the last key returned
+func (c *MongoDBStore) Scan(ctx context.Context, pattern string, cursor string, count int) (_ []string, _ string, err error) {
+	defer c.log.track("scan", pattern, time.Now(), &err)
+
+	count = scanCount(count)
+
+	ctx, cancel := c.timeouts.read(ctx)
+	defer cancel()
+
+	var query = c.aliveQuery(bson.M{
+		"$regex": globToRegexp(pattern),
+		"$gt":    cursor,
+	})
+	var opts = options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetLimit(int64(count)).
+		SetProjection(bson.M{"_id": 1})
+
+	cur, err := c.getCollection().Find(ctx, query, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cur.Close(ctx)
+
+	var keys []string
+	for cur.Next(ctx) {
+		var item mongoItem
+		if err := cur.Decode(&item); err != nil {
+			return nil, "", err
+		}
+		keys = append(keys, item.Key)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(keys) < count {
+		return keys, "", nil
+	}
+	return keys, keys[len(keys)-1], nil
+}
+
+// Increment uses $inc with upsert, an expired counter is removed first so it
+// restarts from zero. The upsert of a key holding a value fails on the
+// duplicate _id, which is reported as ErrNotCounter
+func (c *MongoDBStore) Increment(key string, delta int64, expiration ...time.Duration) (_ int64, err error) {
+	defer c.log.track("increment", key, time.Now(), &err)
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
 	}
 
+	var expiredAt int64
 	if exp > 0 {
-		content.ExpiredAt = time.Now().Add(exp).Unix()
+		expiredAt = time.Now().Add(exp).Unix()
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := c.timeouts.write(context.Background())
 	defer cancel()
 
-	var query = bson.M{"_id": key}
+	var expired = bson.M{
+		"_id":        key,
+		"expired_at": bson.M{"$gt": 0, "$lte": time.Now().Unix()},
+	}
+	if _, err := c.getCollection().DeleteOne(ctx, expired); err != nil {
+		return 0, err
+	}
+
 	var update = bson.M{
-		"$set": content,
+		"$inc":         bson.M{"counter": delta},
+		"$setOnInsert": bson.M{"expired_at": expiredAt},
+	}
+	var opts = options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	var query = bson.M{"_id": key, "counter": bson.M{"$exists": true}}
+	var content mongoItem
+	err = c.getCollection().FindOneAndUpdate(ctx, query, update, opts).Decode(&content)
+	if mongo.IsDuplicateKeyError(err) {
+		// Either a value or a counter created concurrently, which the retry finds
+		content = mongoItem{}
+		err = c.getCollection().FindOneAndUpdate(ctx, query, update, opts).Decode(&content)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return 0, ErrNotCounter
 	}
-	result, err := c.getCollection().UpdateOne(ctx, query, &update)
+	if err != nil {
+		return 0, err
+	}
+	return *content.Counter, nil
+}
+
+func (c *MongoDBStore) Decrement(key string, delta int64, expiration ...time.Duration) (int64, error) {
+	return c.Increment(key, -delta, expiration...)
+}
+
+// Add inserts the item, an expired item under the same key is replaced
+func (c *MongoDBStore) Add(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("add", key, time.Now(), &err)
+
+	content, err := c.newItem(key, value, expiration...)
 	if err != nil {
 		return err
 	}
+	content.Version = 1
 
-	if result.MatchedCount == 0 {
-		_, err := c.getCollection().InsertOne(ctx, &content)
-		if err != nil {
-			return err
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	var expired = bson.M{
+		"_id":        key,
+		"expired_at": bson.M{"$gt": 0, "$lte": time.Now().Unix()},
+	}
+	if _, err := c.getCollection().DeleteOne(ctx, expired); err != nil {
+		return err
+	}
+
+	_, err = c.getCollection().InsertOne(ctx, content)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrNotStored
 		}
+		return err
+	}
+	return nil
+}
+
+func (c *MongoDBStore) Replace(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("replace", key, time.Now(), &err)
+
+	content, err := c.newItem(key, value, expiration...)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	result, err := c.getCollection().UpdateOne(ctx, c.aliveQuery(key), content.update())
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotStored
+	}
+	return nil
+}
+
+// GetCAS returns the item version as token
+func (c *MongoDBStore) GetCAS(key string, value interface{}) (_ *CASToken, err error) {
+	defer c.log.track("get_cas", key, time.Now(), &err)
+
+	if !isPtr(value) {
+		return nil, ErrMustBePointer
+	}
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	content, err := c.findItem(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := content.bytes(c.codec)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		return nil, ErrUnmarshal
+	}
+	return &CASToken{value: content.Version}, nil
+}
+
+// CompareAndSwap updates the item only while its version matches the token
+func (c *MongoDBStore) CompareAndSwap(key string, value interface{}, token *CASToken, expiration ...time.Duration) (err error) {
+	defer c.log.track("compare_and_swap", key, time.Now(), &err)
+
+	version, ok := token.version().(int64)
+	if !ok {
+		return ErrCASConflict
+	}
 
+	content, err := c.newItem(key, value, expiration...)
+	if err != nil {
+		return err
 	}
 
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	var query = c.aliveQuery(key)
+	query["version"] = version
+	result, err := c.getCollection().UpdateOne(ctx, query, content.update())
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrCASConflict
+	}
 	return nil
 }
 
-func (c *MongoDBStore) Delete(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// CompareAndDelete deletes the item only while its version matches the token
+func (c *MongoDBStore) CompareAndDelete(key string, token *CASToken) (err error) {
+	defer c.log.track("compare_and_delete", key, time.Now(), &err)
+
+	version, ok := token.version().(int64)
+	if !ok {
+		return ErrCASConflict
+	}
+
+	ctx, cancel := c.timeouts.write(context.Background())
 	defer cancel()
-	var query = bson.M{"_id": key}
-	if _, err := c.getCollection().DeleteOne(ctx, query); err != nil {
+
+	var query = bson.M{"_id": key, "version": version}
+	result, err := c.getCollection().DeleteOne(ctx, query)
+	if err != nil {
 		return err
 	}
+	if result.DeletedCount == 0 {
+		return ErrCASConflict
+	}
 	return nil
 }
 
+// Ping checks the primary within the read timeout
+func (c *MongoDBStore) Ping(ctx context.Context) (err error) {
+	defer c.log.track("ping", "", time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(ctx)
+	defer cancel()
+
+	return c.client.Ping(ctx, nil)
+}
+
+// Close disconnects the client within the write timeout
+func (c *MongoDBStore) Close() error {
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	return c.client.Disconnect(ctx)
+}
+
+// Codec returns the codec of values
+func (c *MongoDBStore) Codec() Codec {
+	return c.codec
+}
+
 func (c *MongoDBStore) Type() string {
 	return "mongodb"
 }
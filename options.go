@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidOptions is wrapped by the errors of the Open constructors for
+// configuration mistakes
+var ErrInvalidOptions = errors.New("cache: Invalid options")
+
+// Option overrides a setting shared by every store, options are applied after
+// the fields of the store options struct
+type Option func(config *storeConfig)
+
+// storeConfig holds the settings shared by every store
+type storeConfig struct {
+	DefaultExpiration time.Duration
+	Codec             Codec
+	Logger            Logger
+	SlowThreshold     time.Duration
+	Events            *Events
+	Timeouts          Timeouts
+
+	// invalid is the first mistake found while applying options
+	invalid string
+}
+
+// WithDefaultExpiration sets the lifetime of keys written without an expiration
+func WithDefaultExpiration(expiration time.Duration) Option {
+	return func(config *storeConfig) {
+		config.DefaultExpiration = expiration
+	}
+}
+
+// WithCodec sets the Codec of values, default is DefaultCodec
+func WithCodec(codec Codec) Option {
+	return func(config *storeConfig) {
+		if codec == nil && config.invalid == "" {
+			config.invalid = "Codec must not be nil"
+		}
+		config.Codec = codec
+	}
+}
+
+// WithLogger sets the Logger receiving failed and slow operations
+func WithLogger(logger Logger) Option {
+	return func(config *storeConfig) {
+		config.Logger = logger
+	}
+}
+
+// WithSlowThreshold reports operations slower than threshold, 0 disables it
+func WithSlowThreshold(threshold time.Duration) Option {
+	return func(config *storeConfig) {
+		config.SlowThreshold = threshold
+	}
+}
+
+// WithEvents sets the callbacks fired by the store
+func WithEvents(events *Events) Option {
+	return func(config *storeConfig) {
+		config.Events = events
+	}
+}
+
+// WithTimeouts bounds the reads and writes of remote stores, in process stores ignore it
+func WithTimeouts(timeouts Timeouts) Option {
+	return func(config *storeConfig) {
+		config.Timeouts = timeouts
+	}
+}
+
+// apply applies opts and validates the result, a nil Codec becomes DefaultCodec
+func (config storeConfig) apply(store string, opts []Option) (storeConfig, error) {
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	if config.invalid != "" {
+		return config, invalidOptions(store, "%s", config.invalid)
+	}
+	if config.Codec == nil {
+		config.Codec = DefaultCodec
+	}
+	// A negative DefaultExpiration, like go-cache NoExpiration, never expires
+	if config.DefaultExpiration < 0 {
+		config.DefaultExpiration = NoExpiration
+	}
+	if config.SlowThreshold < 0 {
+		return config, invalidOptions(store, "SlowThreshold must not be negative, got %v", config.SlowThreshold)
+	}
+	if config.Timeouts.Read < 0 || config.Timeouts.Write < 0 {
+		return config, invalidOptions(store, "Timeouts must not be negative, got %+v", config.Timeouts)
+	}
+	return config, nil
+}
+
+// invalidOptions returns a descriptive error wrapping ErrInvalidOptions
+func invalidOptions(store string, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s: %s", ErrInvalidOptions, store, fmt.Sprintf(format, args...))
+}
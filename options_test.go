@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenValidatesOptions(t *testing.T) {
+	// Negative values disable the expiration and the janitor
+	store, err := OpenMemoryStore(MemoryStoreOptions{DefaultExpiration: -1, CleanupInterval: -time.Second})
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, store.DefaultExpiration)
+	assert.NoError(t, store.Close())
+
+	store, err = OpenMemoryStore(MemoryStoreOptions{}, WithDefaultExpiration(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, store.DefaultExpiration)
+	assert.NoError(t, store.Close())
+
+	_, err = OpenMemoryStore(MemoryStoreOptions{}, WithCodec(nil))
+	assert.EqualError(t, err, "cache: Invalid options: memory: Codec must not be nil")
+
+	_, err = OpenRistrettoStore(&RistrettoStoreOptions{MaxCost: 1 << 20, BufferItems: 64})
+	assert.EqualError(t, err, "cache: Invalid options: ristretto: NumCounters must be positive, got 0")
+
+	_, err = OpenRedisStore(&RedisStoreOptions{})
+	assert.EqualError(t, err, "cache: Invalid options: redis: Address is required")
+
+	_, err = OpenRedisStore(&RedisStoreOptions{Address: "localhost:6379"}, WithTimeouts(Timeouts{Read: -time.Second}))
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = OpenMemcacheStore(&MemcacheStoreOptions{})
+	assert.Equal(t, ErrMemcacheServerRequired, err)
+
+	_, err = OpenMemcacheStore(&MemcacheStoreOptions{Servers: []string{"localhost:not-a-port"}})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = OpenMongoDBStore(MongoDBStoreOptions{DatabaseURI: "mongodb://localhost:27017"})
+	assert.EqualError(t, err, "cache: Invalid options: mongodb: DatabaseName is required")
+
+	_, err = OpenBadgerStore(&BadgerStoreOptions{})
+	assert.EqualError(t, err, "cache: Invalid options: badger: Dir is required unless InMemory is set")
+
+	_, err = OpenBadgerStore(&BadgerStoreOptions{InMemory: true, GCDiscardRatio: 1})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = OpenEtcdStore(EtcdStoreOptions{})
+	assert.EqualError(t, err, "cache: Invalid options: etcd: Client or Endpoints is required")
+
+	_, err = OpenBoltStore(&BoltStoreOptions{})
+	assert.EqualError(t, err, "cache: Invalid options: bolt: Path is required")
+
+	assert.Panics(t, func() {
+		NewMemcacheStore(&MemcacheStoreOptions{})
+	})
+}
+
+func TestStoreOptions(t *testing.T) {
+	store, err := OpenMemoryStore(MemoryStoreOptions{DefaultExpiration: time.Hour},
+		WithDefaultExpiration(time.Minute),
+		WithCodec(JSONCodec{}),
+	)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	var item = CacheItem{Name: "Hello world"}
+	assert.NoError(t, store.Set("test_options", &item))
+
+	ttl, err := store.TTL("test_options")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 59*time.Second && ttl <= time.Minute)
+
+	bytes, err := store.GetBytes("test_options")
+	assert.NoError(t, err)
+	assert.True(t, json.Valid(bytes))
+
+	var out CacheItem
+	assert.NoError(t, store.Get("test_options", &out))
+	assert.Equal(t, item, out)
+
+	// Chain decodes with the codec of its first tier
+	var chain = NewChain(store)
+	assert.Equal(t, JSONCodec{}, chain.Codec())
+	out = CacheItem{}
+	assert.NoError(t, chain.Get("test_options", &out))
+	assert.Equal(t, item, out)
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusMetrics exports Instrumented measurements as Prometheus metrics.
+// Register it with a prometheus.Registerer, it implements prometheus.Collector
+type PrometheusMetrics struct {
+	operations *prometheus.CounterVec
+	durations  *prometheus.HistogramVec
+	sizes      *prometheus.HistogramVec
+}
+
+type PrometheusMetricsOptions struct {
+	// Namespace and Subsystem prefix the metric names, e.g. myapp_cache_operations_total
+	Namespace string
+	Subsystem string
+	// DurationBuckets defaults to prometheus.DefBuckets
+	DurationBuckets []float64
+	// SizeBuckets defaults to powers of 4 from 64 bytes to 4MB
+	SizeBuckets []float64
+}
+
+func NewPrometheusMetrics(options PrometheusMetricsOptions) *PrometheusMetrics {
+	if options.Subsystem == "" {
+		options.Subsystem = "cache"
+	}
+	if options.DurationBuckets == nil {
+		options.DurationBuckets = prometheus.DefBuckets
+	}
+	if options.SizeBuckets == nil {
+		options.SizeBuckets = prometheus.ExponentialBuckets(64, 4, 9)
+	}
+
+	return &PrometheusMetrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: options.Namespace,
+			Subsystem: options.Subsystem,
+			Name:      "operations_total",
+			Help:      "Cache operations by store, namespace, operation and result (hit, miss, ok, error).",
+		}, []string{"store", "namespace", "operation", "result"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: options.Namespace,
+			Subsystem: options.Subsystem,
+			Name:      "operation_duration_seconds",
+			Help:      "Cache operation latency.",
+			Buckets:   options.DurationBuckets,
+		}, []string{"store", "namespace", "operation"}),
+		sizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: options.Namespace,
+			Subsystem: options.Subsystem,
+			Name:      "payload_bytes",
+			Help:      "Cache payload size of reads and writes.",
+			Buckets:   options.SizeBuckets,
+		}, []string{"store", "namespace", "operation"}),
+	}
+}
+
+func (m *PrometheusMetrics) Observe(op Operation) {
+	m.operations.WithLabelValues(op.Store, op.Namespace, op.Name, op.Result).Inc()
+	m.durations.WithLabelValues(op.Store, op.Namespace, op.Name).Observe(op.Duration.Seconds())
+	if op.Size >= 0 && op.Result != ResultError {
+		m.sizes.WithLabelValues(op.Store, op.Namespace, op.Name).Observe(float64(op.Size))
+	}
+}
+
+func (m *PrometheusMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.operations.Describe(ch)
+	m.durations.Describe(ch)
+	m.sizes.Describe(ch)
+}
+
+func (m *PrometheusMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.operations.Collect(ch)
+	m.durations.Collect(ch)
+	m.sizes.Collect(ch)
+}
@@ -0,0 +1,299 @@
+package cache
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// RateLimitResult is the outcome of a rate limit check, its fields map to the
+// usual X-RateLimit-* and Retry-After response headers
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	// ResetAt is when the quota is fully available again
+	ResetAt time.Time
+	// RetryAfter is how long to wait before retrying a rejected request
+	RetryAfter time.Duration
+}
+
+// RateLimiter decides whether a request identified by key may proceed
+type RateLimiter interface {
+	Allow(key string) (*RateLimitResult, error)
+
+	AllowN(key string, n int64) (*RateLimitResult, error)
+}
+
+type RateLimiterOptions struct {
+	// Prefix is prepended to the keys written to the store
+	Prefix string
+	// Limit is the number of requests allowed per Window,
+	// or the bucket capacity of a token bucket refilled at Limit per Window
+	Limit  int64
+	Window time.Duration
+}
+
+// validate rejects options no limiter can count with
+func (o RateLimiterOptions) validate(limiter string) error {
+	switch {
+	case o.Limit <= 0:
+		return invalidOptions(limiter, "Limit must be positive, got %d", o.Limit)
+	case o.Window <= 0:
+		return invalidOptions(limiter, "Window must be positive, got %v", o.Window)
+	}
+	return nil
+}
+
+// FixedWindowLimiter counts requests in consecutive windows of fixed length
+type FixedWindowLimiter struct {
+	store  Counter
+	prefix string
+	limit  int64
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewFixedWindowLimiter panics on invalid options, use OpenFixedWindowLimiter to handle them
+func NewFixedWindowLimiter(store Counter, options RateLimiterOptions) *FixedWindowLimiter {
+	limiter, err := OpenFixedWindowLimiter(store, options)
+	if err != nil {
+		panic(err)
+	}
+	return limiter
+}
+
+// OpenFixedWindowLimiter returns ErrInvalidOptions unless Limit and Window are positive
+func OpenFixedWindowLimiter(store Counter, options RateLimiterOptions) (*FixedWindowLimiter, error) {
+	if err := options.validate("fixed_window"); err != nil {
+		return nil, err
+	}
+
+	return &FixedWindowLimiter{
+		store:  store,
+		prefix: options.Prefix,
+		limit:  options.Limit,
+		window: options.Window,
+		now:    time.Now,
+	}, nil
+}
+
+func (l *FixedWindowLimiter) Allow(key string) (*RateLimitResult, error) {
+	return l.AllowN(key, 1)
+}
+
+func (l *FixedWindowLimiter) AllowN(key string, n int64) (*RateLimitResult, error) {
+	var now = l.now()
+	var start = now.Truncate(l.window)
+
+	count, err := l.store.Increment(windowKey(l.prefix, key, start, l.window), n, l.window)
+	if err != nil {
+		return nil, err
+	}
+
+	var result = &RateLimitResult{
+		Allowed:   count <= l.limit,
+		Limit:     l.limit,
+		Remaining: clampRemaining(l.limit - count),
+		ResetAt:   start.Add(l.window),
+	}
+	if !result.Allowed {
+		result.RetryAfter = result.ResetAt.Sub(now)
+	}
+	return result, nil
+}
+
+// SlidingWindowLimiter weighs the previous window count by its overlap with a
+// window sliding up to now, which smooths the bursts fixed windows allow at
+// their boundaries. Rejected requests are not counted
+type SlidingWindowLimiter struct {
+	store  Counter
+	prefix string
+	limit  int64
+	window time.Duration
+	now    func() time.Time
+}
+
+// NewSlidingWindowLimiter panics on invalid options, use OpenSlidingWindowLimiter to handle them
+func NewSlidingWindowLimiter(store Counter, options RateLimiterOptions) *SlidingWindowLimiter {
+	limiter, err := OpenSlidingWindowLimiter(store, options)
+	if err != nil {
+		panic(err)
+	}
+	return limiter
+}
+
+// OpenSlidingWindowLimiter returns ErrInvalidOptions unless Limit and Window are positive
+func OpenSlidingWindowLimiter(store Counter, options RateLimiterOptions) (*SlidingWindowLimiter, error) {
+	if err := options.validate("sliding_window"); err != nil {
+		return nil, err
+	}
+
+	return &SlidingWindowLimiter{
+		store:  store,
+		prefix: options.Prefix,
+		limit:  options.Limit,
+		window: options.Window,
+		now:    time.Now,
+	}, nil
+}
+
+func (l *SlidingWindowLimiter) Allow(key string) (*RateLimitResult, error) {
+	return l.AllowN(key, 1)
+}
+
+func (l *SlidingWindowLimiter) AllowN(key string, n int64) (*RateLimitResult, error) {
+	var now = l.now()
+	var start = now.Truncate(l.window)
+	var elapsed = now.Sub(start)
+
+	// Counters live for two windows so the previous one can still be read
+	previous, err := l.store.Increment(windowKey(l.prefix, key, start.Add(-l.window), l.window), 0, 2*l.window)
+	if err != nil {
+		return nil, err
+	}
+
+	var currentKey = windowKey(l.prefix, key, start, l.window)
+	current, err := l.store.Increment(currentKey, n, 2*l.window)
+	if err != nil {
+		return nil, err
+	}
+
+	var weight = 1 - float64(elapsed)/float64(l.window)
+	var estimated = float64(previous)*weight + float64(current)
+
+	var result = &RateLimitResult{
+		Allowed:   estimated <= float64(l.limit),
+		Limit:     l.limit,
+		Remaining: clampRemaining(int64(math.Floor(float64(l.limit) - estimated))),
+		ResetAt:   start.Add(l.window),
+	}
+	if result.Allowed {
+		return result, nil
+	}
+
+	if _, err := l.store.Decrement(currentKey, n); err != nil {
+		return nil, err
+	}
+
+	// Wait until enough of the previous window has slid out, or the current one ends
+	result.RetryAfter = result.ResetAt.Sub(now)
+	if free := float64(l.limit - current); free >= 0 && previous > 0 {
+		var wait = time.Duration(float64(l.window) * (weight - free/float64(previous)))
+		if wait > 0 && wait < result.RetryAfter {
+			result.RetryAfter = wait
+		}
+	}
+	return result, nil
+}
+
+// TokenBucketLimiter holds up to Limit tokens refilled at Limit per Window,
+// each request takes n tokens. The bucket state is updated with CompareAndSwap
+type TokenBucketLimiter struct {
+	store    ConditionalWriter
+	prefix   string
+	capacity int64
+	window   time.Duration
+	now      func() time.Time
+}
+
+type tokenBucket struct {
+	Tokens    float64
+	UpdatedAt int64
+}
+
+// tokenBucketAttempts bounds the CompareAndSwap retries under contention
+const tokenBucketAttempts = 10
+
+// NewTokenBucketLimiter panics on invalid options, use OpenTokenBucketLimiter to handle them
+func NewTokenBucketLimiter(store ConditionalWriter, options RateLimiterOptions) *TokenBucketLimiter {
+	limiter, err := OpenTokenBucketLimiter(store, options)
+	if err != nil {
+		panic(err)
+	}
+	return limiter
+}
+
+// OpenTokenBucketLimiter returns ErrInvalidOptions unless Limit and Window are positive
+func OpenTokenBucketLimiter(store ConditionalWriter, options RateLimiterOptions) (*TokenBucketLimiter, error) {
+	if err := options.validate("token_bucket"); err != nil {
+		return nil, err
+	}
+
+	return &TokenBucketLimiter{
+		store:    store,
+		prefix:   options.Prefix,
+		capacity: options.Limit,
+		window:   options.Window,
+		now:      time.Now,
+	}, nil
+}
+
+func (l *TokenBucketLimiter) Allow(key string) (*RateLimitResult, error) {
+	return l.AllowN(key, 1)
+}
+
+func (l *TokenBucketLimiter) AllowN(key string, n int64) (*RateLimitResult, error) {
+	var rate = float64(l.capacity) / float64(l.window) // tokens per nanosecond
+	var bucketKey = l.prefix + key
+
+	for attempt := 0; attempt < tokenBucketAttempts; attempt++ {
+		var now = l.now()
+		var bucket tokenBucket
+		token, err := l.store.GetCAS(bucketKey, &bucket)
+		if err == ErrKeyNotFound {
+			bucket = tokenBucket{Tokens: float64(l.capacity), UpdatedAt: now.UnixNano()}
+		} else if err != nil {
+			return nil, err
+		}
+
+		var elapsed = now.UnixNano() - bucket.UpdatedAt
+		if elapsed > 0 {
+			bucket.Tokens = math.Min(float64(l.capacity), bucket.Tokens+float64(elapsed)*rate)
+		}
+		bucket.UpdatedAt = now.UnixNano()
+
+		var result = &RateLimitResult{
+			Allowed: bucket.Tokens >= float64(n),
+			Limit:   l.capacity,
+		}
+		if !result.Allowed {
+			result.Remaining = clampRemaining(int64(bucket.Tokens))
+			result.RetryAfter = time.Duration(math.Ceil((float64(n) - bucket.Tokens) / rate))
+			result.ResetAt = now.Add(time.Duration(math.Ceil((float64(l.capacity) - bucket.Tokens) / rate)))
+			return result, nil
+		}
+
+		bucket.Tokens -= float64(n)
+		result.Remaining = clampRemaining(int64(bucket.Tokens))
+		result.ResetAt = now.Add(time.Duration(math.Ceil((float64(l.capacity) - bucket.Tokens) / rate)))
+
+		// An idle bucket is full again after one window, it can expire by then
+		if token == nil {
+			err = l.store.Add(bucketKey, &bucket, l.window)
+		} else {
+			err = l.store.CompareAndSwap(bucketKey, &bucket, token, l.window)
+		}
+		if err == ErrNotStored || err == ErrCASConflict {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	return nil, ErrCASConflict
+}
+
+// windowKey names the counter of the window starting at start by its index
+func windowKey(prefix string, key string, start time.Time, window time.Duration) string {
+	return prefix + key + ":" + strconv.FormatInt(start.UnixNano()/int64(window), 10)
+}
+
+func clampRemaining(remaining int64) int64 {
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixedWindowLimiter(t *testing.T) {
+	var now = time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	var limiter = NewFixedWindowLimiter(NewMemoryStore(MemoryStoreOptions{}), RateLimiterOptions{
+		Prefix: "rate:",
+		Limit:  3,
+		Window: time.Minute,
+	})
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow("client")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, int64(2-i), result.Remaining)
+	}
+
+	now = now.Add(20 * time.Second)
+	result, err := limiter.Allow("client")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 40*time.Second, result.RetryAfter)
+	assert.Equal(t, now.Add(40*time.Second), result.ResetAt)
+
+	now = now.Add(40 * time.Second)
+	result, err = limiter.Allow("client")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	var now = time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	var limiter = NewSlidingWindowLimiter(NewMemoryStore(MemoryStoreOptions{}), RateLimiterOptions{
+		Limit:  4,
+		Window: time.Minute,
+	})
+	limiter.now = func() time.Time { return now }
+
+	result, err := limiter.AllowN("client", 4)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(0), result.Remaining)
+
+	// Half of the previous window still counts: 4*0.5 + 2 = 4
+	now = now.Add(90 * time.Second)
+	result, err = limiter.AllowN("client", 2)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = limiter.Allow("client")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 15*time.Second, result.RetryAfter)
+
+	now = now.Add(15 * time.Second)
+	result, err = limiter.Allow("client")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	var now = time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	var limiter = NewTokenBucketLimiter(NewMemoryStore(MemoryStoreOptions{}), RateLimiterOptions{
+		Limit:  10,
+		Window: 10 * time.Second,
+	})
+	limiter.now = func() time.Time { return now }
+
+	result, err := limiter.AllowN("client", 10)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(0), result.Remaining)
+	assert.Equal(t, now.Add(10*time.Second), result.ResetAt)
+
+	result, err = limiter.Allow("client")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	now = now.Add(3 * time.Second)
+	result, err = limiter.AllowN("client", 2)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, int64(1), result.Remaining)
+}
+
+func TestLimiterOptions(t *testing.T) {
+	var store = NewMemoryStore(MemoryStoreOptions{})
+
+	assert.PanicsWithError(t, "cache: Invalid options: fixed_window: Window must be positive, got 0s", func() {
+		NewFixedWindowLimiter(store, RateLimiterOptions{Limit: 3})
+	})
+	assert.PanicsWithError(t, "cache: Invalid options: sliding_window: Limit must be positive, got 0", func() {
+		NewSlidingWindowLimiter(store, RateLimiterOptions{Window: time.Minute})
+	})
+	assert.Panics(t, func() {
+		NewTokenBucketLimiter(store, RateLimiterOptions{Limit: 3, Window: -time.Minute})
+	})
+
+	_, err := OpenFixedWindowLimiter(store, RateLimiterOptions{Limit: -1, Window: time.Minute})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = OpenSlidingWindowLimiter(store, RateLimiterOptions{Limit: 3})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+	_, err = OpenTokenBucketLimiter(store, RateLimiterOptions{Window: time.Minute})
+	assert.EqualError(t, err, "cache: Invalid options: token_bucket: Limit must be positive, got 0")
+
+	limiter, err := OpenTokenBucketLimiter(store, RateLimiterOptions{Limit: 3, Window: time.Minute})
+	assert.NoError(t, err)
+	assert.NotNil(t, limiter)
+}
@@ -2,15 +2,21 @@ package cache
 
 import (
 	"context"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/vmihailenco/msgpack/v5"
 )
 
 // RedisStore client
 type RedisStore struct {
 	client            *redis.Client
+	prefix            string
+	allowFlushDB      bool
+	timeouts          Timeouts
+	log               storeLogger
+	codec             Codec
 	DefaultExpiration time.Duration
 }
 
@@ -21,6 +27,23 @@ type RedisStoreOptions struct {
 	Password          string
 	DefaultExpiration time.Duration
 
+	// Prefix is prepended to every key and scopes Clear to the store's namespace
+	Prefix string
+	// AllowFlushDB lets Clear run FLUSHDB when no Prefix is set.
+	// Default is to refuse clearing an unprefixed store.
+	AllowFlushDB bool
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes and failures
+	Events *Events
+
+	// Timeouts bound reads and writes, default is TimeoutsDefault.
+	// Operations running out of time return ErrTimeout.
+	Timeouts Timeouts
+
 	MaxRetries int
 	// Minimum backoff between each retry.
 	// Default is 8 milliseconds; -1 disables backoff.
@@ -65,7 +88,41 @@ type RedisStoreOptions struct {
 	IdleCheckFrequency time.Duration
 }
 
-func NewRedisStore(options *RedisStoreOptions) *RedisStore {
+// NewRedisStore panics on invalid options, use OpenRedisStore to handle them
+func NewRedisStore(options *RedisStoreOptions, opts ...Option) *RedisStore {
+	store, err := OpenRedisStore(options, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// OpenRedisStore validates options and creates the store. The connection is
+// lazy, use Ping to check the server is reachable
+func OpenRedisStore(options *RedisStoreOptions, opts ...Option) (*RedisStore, error) {
+	if options == nil {
+		return nil, invalidOptions("redis", "options are required")
+	}
+
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+		Timeouts:          options.Timeouts,
+	}.apply("redis", opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case options.Address == "":
+		return nil, invalidOptions("redis", "Address is required")
+	case options.DB < 0:
+		return nil, invalidOptions("redis", "DB must not be negative, got %d", options.DB)
+	case options.PoolSize < 0:
+		return nil, invalidOptions("redis", "PoolSize must not be negative, got %d", options.PoolSize)
+	}
+
 	var opt = &redis.Options{
 		Addr:     options.Address,
 		DB:       options.DB,
@@ -119,8 +176,13 @@ func NewRedisStore(options *RedisStoreOptions) *RedisStore {
 
 	return &RedisStore{
 		client:            client,
-		DefaultExpiration: options.DefaultExpiration,
-	}
+		prefix:            options.Prefix,
+		allowFlushDB:      options.AllowFlushDB,
+		timeouts:          config.Timeouts.orDefault(TimeoutsDefault),
+		log:               newStoreLogger("redis", config.Logger, config.SlowThreshold, config.Events),
+		codec:             config.Codec,
+		DefaultExpiration: config.DefaultExpiration,
+	}, nil
 }
 
 func (c *RedisStore) Get(key string, value interface{}) error {
@@ -128,59 +190,495 @@ func (c *RedisStore) Get(key string, value interface{}) error {
 		return ErrMustBePointer
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	err = c.codec.Unmarshal(decodeCounter(c.codec, bytes), value)
+	if err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+func (c *RedisStore) GetBytes(key string) (_ []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
 	defer cancel()
 
-	val, err := c.client.Get(ctx, key).Result()
+	bytes, err := c.client.Get(ctx, c.prefix+key).Bytes()
 	if err != nil {
 		if err == redis.Nil {
-			return ErrKeyNotFound
+			return nil, ErrKeyNotFound
 		}
+		return nil, err
+	}
+	return bytes, nil
+}
+
+func (c *RedisStore) Set(key string, value interface{}, expiration ...time.Duration) error {
+	bytes, exp, err := c.encode(value, expiration...)
+	if err != nil {
 		return err
 	}
 
-	err = msgpack.Unmarshal([]byte(val), value)
+	return c.SetBytes(key, bytes, exp)
+}
+
+// SetBytes stores bytes as is, bypassing the codec
+func (c *RedisStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	_, err = c.set(key, bytes, exp, "")
+	return err
+}
+
+// redisSetScript runs SET with the NX or XX condition in ARGV[3] and removes
+// the CAS version when the key is written
+var redisSetScript = redis.NewScript(`
+local args = {"SET", KEYS[1], ARGV[1]}
+if tonumber(ARGV[2]) > 0 then
+	table.insert(args, "PX")
+	table.insert(args, ARGV[2])
+end
+if ARGV[3] ~= "" then
+	table.insert(args, ARGV[3])
+end
+if not redis.call(unpack(args)) then
+	return 0
+end
+redis.call("DEL", KEYS[2])
+return 1
+`)
+
+// set writes bytes under the condition, "NX", "XX" or "" for none, and
+// reports whether it was met
+func (c *RedisStore) set(key string, bytes []byte, exp time.Duration, condition string) (bool, error) {
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	var keys = []string{c.prefix + key, c.versionKey(key)}
+	set, err := redisSetScript.Run(ctx, c.client, keys, bytes, redisMilliseconds(exp), condition).Int()
+	return set == 1, err
+}
+
+func (c *RedisStore) Delete(key string) (err error) {
+	defer c.log.track("delete", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	err = c.client.Del(ctx, c.prefix+key, c.versionKey(key)).Err()
 	if err != nil {
-		return ErrUnmarshal
+		return err
 	}
 	return nil
 }
 
-func (c *RedisStore) Set(key string, value interface{}, expiration ...time.Duration) error {
-	if !isPtr(value) {
-		return ErrMustBePointer
+func (c *RedisStore) Exists(key string) (_ bool, err error) {
+	defer c.log.track("exists", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	n, err := c.client.Exists(ctx, c.prefix+key).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c *RedisStore) TTL(key string) (_ time.Duration, err error) {
+	defer c.log.track("ttl", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	ttl, err := c.client.PTTL(ctx, c.prefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	switch ttl {
+	case -2:
+		return 0, ErrKeyNotFound
+	case -1:
+		return NoExpiration, nil
+	}
+	return ttl, nil
+}
+
+func (c *RedisStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	// The CAS version follows the key, so it does not expire before it
+	var cmd *redis.BoolCmd
+	_, err = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if ttl > 0 {
+			cmd = pipe.PExpire(ctx, c.prefix+key, ttl)
+			pipe.PExpire(ctx, c.versionKey(key), ttl)
+		} else {
+			cmd = pipe.Persist(ctx, c.prefix+key)
+			pipe.Persist(ctx, c.versionKey(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ok := cmd.Val()
+	if !ok {
+		// PERSIST also reports false for a key that has no expiry
+		if ttl <= 0 {
+			if found, err := c.Exists(key); err == nil && found {
+				return nil
+			}
+		}
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// Clear removes every key under the store prefix with SCAN and UNLINK.
+// Without a prefix it runs FLUSHDB, only when AllowFlushDB is set
+func (c *RedisStore) Clear() (err error) {
+	defer c.log.track("clear", "", time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	if c.prefix == "" {
+		if !c.allowFlushDB {
+			return ErrClearNotAllowed
+		}
+		return c.client.FlushDB(ctx).Err()
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, redisEscapePattern(c.prefix)+"*", 1000).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			if err := c.client.Unlink(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// Scan uses SCAN within the store prefix, the cursor is the Redis cursor.
+// As with SCAN, a page may hold fewer or more keys than count
+func (c *RedisStore) Scan(ctx context.Context, pattern string, cursor string, count int) (_ []string, _ string, err error) {
+	defer c.log.track("scan", pattern, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(ctx)
+	defer cancel()
+
+	var from uint64
+	if cursor != "" {
+		var err error
+		from, err = strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, "", err
+		}
 	}
 
-	bytes, err := msgpack.Marshal(value)
+	keys, next, err := c.client.Scan(ctx, from, redisEscapePattern(c.prefix)+pattern, int64(scanCount(count))).Result()
 	if err != nil {
-		return ErrMarshal
+		return nil, "", err
 	}
+
+	var found = keys[:0]
+	for _, key := range keys {
+		if !strings.Contains(key, redisVersionTag) {
+			found = append(found, strings.TrimPrefix(key, c.prefix))
+		}
+	}
+	keys = found
+
+	if next == 0 {
+		return keys, "", nil
+	}
+	return keys, strconv.FormatUint(next, 10), nil
+}
+
+// redisIncrementScript runs INCRBY and sets the expiration only when the key
+// is created, the CAS version is removed
+var redisIncrementScript = redis.NewScript(`
+local created = redis.call("EXISTS", KEYS[1]) == 0
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if created and tonumber(ARGV[2]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+redis.call("DEL", KEYS[2])
+return value
+`)
+
+func (c *RedisStore) Increment(key string, delta int64, expiration ...time.Duration) (_ int64, err error) {
+	defer c.log.track("increment", key, time.Now(), &err)
+
 	var exp = c.DefaultExpiration
 	if len(expiration) > 0 {
 		exp = expiration[0]
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := c.timeouts.write(context.Background())
 	defer cancel()
 
-	err = c.client.Set(ctx, key, bytes, exp).Err()
+	var keys = []string{c.prefix + key, c.versionKey(key)}
+	value, err := redisIncrementScript.Run(ctx, c.client, keys, delta, redisMilliseconds(exp)).Int64()
+	if err != nil && strings.Contains(err.Error(), "not an integer") {
+		return 0, ErrNotCounter
+	}
+	return value, err
+}
+
+func (c *RedisStore) Decrement(key string, delta int64, expiration ...time.Duration) (int64, error) {
+	return c.Increment(key, -delta, expiration...)
+}
+
+func (c *RedisStore) Add(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("add", key, time.Now(), &err)
+
+	bytes, exp, err := c.encode(value, expiration...)
 	if err != nil {
 		return err
 	}
+
+	ok, err := c.set(key, bytes, exp, "NX")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotStored
+	}
 	return nil
 }
 
-func (c *RedisStore) Delete(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (c *RedisStore) Replace(key string, value interface{}, expiration ...time.Duration) (err error) {
+	defer c.log.track("replace", key, time.Now(), &err)
+
+	bytes, exp, err := c.encode(value, expiration...)
+	if err != nil {
+		return err
+	}
+
+	ok, err := c.set(key, bytes, exp, "XX")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotStored
+	}
+	return nil
+}
+
+// redisGetCASScript reads the key and its CAS version, a key without one gets
+// the next number of the store sequence, expiring with the key
+var redisGetCASScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return false
+end
+local version = redis.call("GET", KEYS[2])
+if not version then
+	version = redis.call("INCR", KEYS[3])
+	redis.call("SET", KEYS[2], version)
+	local ttl = redis.call("PTTL", KEYS[1])
+	if ttl > 0 then
+		redis.call("PEXPIRE", KEYS[2], ttl)
+	end
+end
+return {value, tostring(version)}
+`)
+
+// GetCAS returns the version of the key as token. Every write removes the
+// version, so a value written back after a change does not match
+func (c *RedisStore) GetCAS(key string, value interface{}) (_ *CASToken, err error) {
+	defer c.log.track("get_cas", key, time.Now(), &err)
+
+	if !isPtr(value) {
+		return nil, ErrMustBePointer
+	}
+
+	ctx, cancel := c.timeouts.read(context.Background())
 	defer cancel()
 
-	var err = c.client.Del(ctx, key).Err()
+	var keys = []string{c.prefix + key, c.versionKey(key), c.prefix + redisSequenceKey}
+	result, err := redisGetCASScript.Run(ctx, c.client, keys).Slice()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	val, _ := result[0].(string)
+	version, _ := result[1].(string)
+
+	err = c.codec.Unmarshal(decodeCounter(c.codec, []byte(val)), value)
+	if err != nil {
+		return nil, ErrUnmarshal
+	}
+	return &CASToken{value: version}, nil
+}
+
+// redisCompareAndSwapScript sets the key only while it still has the version read with GetCAS
+var redisCompareAndSwapScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 or redis.call("GET", KEYS[2]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+redis.call("DEL", KEYS[2])
+return 1
+`)
+
+func (c *RedisStore) CompareAndSwap(key string, value interface{}, token *CASToken, expiration ...time.Duration) (err error) {
+	defer c.log.track("compare_and_swap", key, time.Now(), &err)
+
+	version, ok := token.version().(string)
+	if !ok {
+		return ErrCASConflict
+	}
+
+	bytes, exp, err := c.encode(value, expiration...)
 	if err != nil {
 		return err
 	}
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	var keys = []string{c.prefix + key, c.versionKey(key)}
+	swapped, err := redisCompareAndSwapScript.Run(ctx, c.client, keys, version, bytes, redisMilliseconds(exp)).Int()
+	if err != nil {
+		return err
+	}
+	if swapped == 0 {
+		return ErrCASConflict
+	}
 	return nil
 }
 
+// redisCompareAndDeleteScript deletes the key only while it still has the version read with GetCAS
+var redisCompareAndDeleteScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 or redis.call("GET", KEYS[2]) ~= ARGV[1] then
+	return 0
+end
+return redis.call("DEL", KEYS[1], KEYS[2])
+`)
+
+func (c *RedisStore) CompareAndDelete(key string, token *CASToken) (err error) {
+	defer c.log.track("compare_and_delete", key, time.Now(), &err)
+
+	version, ok := token.version().(string)
+	if !ok {
+		return ErrCASConflict
+	}
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	var keys = []string{c.prefix + key, c.versionKey(key)}
+	deleted, err := redisCompareAndDeleteScript.Run(ctx, c.client, keys, version).Int()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrCASConflict
+	}
+	return nil
+}
+
+// redisVersionTag marks the keys holding CAS versions, Scan hides them
+const redisVersionTag = "\x00cas"
+
+// redisSequenceKey numbers the CAS versions of the store
+const redisSequenceKey = redisVersionTag + ":sequence"
+
+// versionKey holds the CAS version of key
+func (c *RedisStore) versionKey(key string) string {
+	return c.prefix + key + redisVersionTag
+}
+
+// redisMilliseconds rounds a positive expiration under a millisecond up, 0 means none
+func redisMilliseconds(exp time.Duration) int64 {
+	if exp > 0 && exp < time.Millisecond {
+		return 1
+	}
+	return exp.Milliseconds()
+}
+
+// encode marshals value and resolves its expiration, values that would read as
+// a counter are encoded again
+func (c *RedisStore) encode(value interface{}, expiration ...time.Duration) ([]byte, time.Duration, error) {
+	if !isPtr(value) {
+		return nil, 0, ErrMustBePointer
+	}
+
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return nil, 0, ErrMarshal
+	}
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+	return encodeNonCounter(c.codec, bytes), exp, nil
+}
+
+// Ping sends PING within the read timeout
+func (c *RedisStore) Ping(ctx context.Context) (err error) {
+	defer c.log.track("ping", "", time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(ctx)
+	defer cancel()
+
+	return c.client.Ping(ctx).Err()
+}
+
+// Close closes the connection pool
+func (c *RedisStore) Close() error {
+	return c.client.Close()
+}
+
+func (c *RedisStore) decimalCounters() {}
+
+// Codec returns the codec of values
+func (c *RedisStore) Codec() Codec {
+	return c.codec
+}
+
 func (c *RedisStore) Type() string {
 	return "redis"
 }
+
+// redisEscapePattern escapes the glob characters of s for use in a SCAN MATCH pattern
+func redisEscapePattern(s string) string {
+	var replacer = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+	return replacer.Replace(s)
+}
@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type RetryOptions struct {
+	// MaxAttempts is the number of calls made for an operation, including the first one
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry, it doubles with every retry
+	MinBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+	// Jitter shortens every wait by a random share of up to Jitter, between 0 and 1
+	Jitter float64
+	// Retryable decides whether a store failure is worth retrying, default is
+	// IsRetryable. Misses, failed write conditions and codec errors are never retried
+	Retryable func(err error) bool
+
+	// BudgetRatio is the number of retries earned by every operation, so retries
+	// stay a bounded share of the traffic while the store is down.
+	// Default is 0.1; -1 disables the budget.
+	BudgetRatio float64
+	// BudgetBurst is the number of retries that can be spent at once.
+	// Default is 10.
+	BudgetBurst int
+}
+
+var RetryOptionsDefault = &RetryOptions{
+	MaxAttempts: 3,
+	MinBackoff:  10 * time.Millisecond,
+	MaxBackoff:  time.Second,
+	Jitter:      0.5,
+	BudgetRatio: 0.1,
+	BudgetBurst: 10,
+}
+
+// IsRetryable reports whether err may be transient, a canceled context is not
+func IsRetryable(err error) bool {
+	return !errors.Is(err, context.Canceled)
+}
+
+// Retrying retries the failed operations of the wrapped cache with exponential
+// backoff. Operations of the Cache interface are idempotent, counters and
+// conditional writes are not wrapped. Over a single store Get and Set go
+// through GetBytes and SetBytes, so values are encoded outside of the retry
+// loop. Over a Chain or another wrapper they call its Get and Set, which keeps
+// its hooks and write path
+type Retrying struct {
+	Cache
+	options RetryOptions
+	sleep   func(d time.Duration)
+	random  func() float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func NewRetrying(cache Cache, options *RetryOptions) *Retrying {
+	if options == nil {
+		options = RetryOptionsDefault
+	}
+
+	var retrying = &Retrying{
+		Cache:   cache,
+		options: *options,
+		sleep:   time.Sleep,
+		random:  rand.Float64,
+	}
+	if retrying.options.MaxAttempts <= 0 {
+		retrying.options.MaxAttempts = RetryOptionsDefault.MaxAttempts
+	}
+	if retrying.options.MinBackoff <= 0 {
+		retrying.options.MinBackoff = RetryOptionsDefault.MinBackoff
+	}
+	if retrying.options.MaxBackoff < retrying.options.MinBackoff {
+		retrying.options.MaxBackoff = retrying.options.MinBackoff
+	}
+	if retrying.options.Retryable == nil {
+		retrying.options.Retryable = IsRetryable
+	}
+	if retrying.options.BudgetRatio == 0 {
+		retrying.options.BudgetRatio = RetryOptionsDefault.BudgetRatio
+	}
+	if retrying.options.BudgetBurst <= 0 {
+		retrying.options.BudgetBurst = RetryOptionsDefault.BudgetBurst
+	}
+	retrying.tokens = float64(retrying.options.BudgetBurst)
+
+	return retrying
+}
+
+// Unwrap returns the wrapped cache
+func (c *Retrying) Unwrap() Cache {
+	return c.Cache
+}
+
+// Get retries reading the bytes of a single store and decodes them once, so a
+// value that cannot be decoded is never read again
+func (c *Retrying) Get(key string, value interface{}) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+	if !isStore(c.Cache) {
+		return c.do(func() error {
+			return c.Cache.Get(key, value)
+		})
+	}
+
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+	return decodeValue(codecOf(c.Cache), c.Cache, bytes, value)
+}
+
+// Set encodes value once and retries writing the bytes to a single store
+func (c *Retrying) Set(key string, value interface{}, expiration ...time.Duration) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+	if !isStore(c.Cache) {
+		return c.do(func() error {
+			return c.Cache.Set(key, value, expiration...)
+		})
+	}
+
+	bytes, err := encodeValue(codecOf(c.Cache), c.Cache, value)
+	if err != nil {
+		return err
+	}
+
+	return c.SetBytes(key, bytes, expiration...)
+}
+
+func (c *Retrying) Delete(key string) error {
+	return c.do(func() error {
+		return c.Cache.Delete(key)
+	})
+}
+
+func (c *Retrying) GetBytes(key string) ([]byte, error) {
+	var bytes []byte
+	var err = c.do(func() (err error) {
+		bytes, err = c.Cache.GetBytes(key)
+		return err
+	})
+	return bytes, err
+}
+
+func (c *Retrying) SetBytes(key string, bytes []byte, expiration ...time.Duration) error {
+	return c.do(func() error {
+		return c.Cache.SetBytes(key, bytes, expiration...)
+	})
+}
+
+func (c *Retrying) Exists(key string) (bool, error) {
+	var found bool
+	var err = c.do(func() (err error) {
+		found, err = c.Cache.Exists(key)
+		return err
+	})
+	return found, err
+}
+
+func (c *Retrying) TTL(key string) (time.Duration, error) {
+	var ttl time.Duration
+	var err = c.do(func() (err error) {
+		ttl, err = c.Cache.TTL(key)
+		return err
+	})
+	return ttl, err
+}
+
+func (c *Retrying) Touch(key string, ttl time.Duration) error {
+	return c.do(func() error {
+		return c.Cache.Touch(key, ttl)
+	})
+}
+
+func (c *Retrying) Clear() error {
+	return c.do(func() error {
+		return c.Cache.Clear()
+	})
+}
+
+func (c *Retrying) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	var keys []string
+	var next string
+	var err = c.do(func() (err error) {
+		keys, next, err = c.Cache.Scan(ctx, pattern, cursor, count)
+		return err
+	})
+	return keys, next, err
+}
+
+// isStore reports whether cache is a single store, whose Get and Set only
+// wrap GetBytes and SetBytes with its codec
+func isStore(cache Cache) bool {
+	switch cache.(type) {
+	case *Chain, interface{ Unwrap() Cache }:
+		return false
+	}
+	return true
+}
+
+// do calls fn until it succeeds, fails for good, runs out of attempts or the budget is spent
+func (c *Retrying) do(fn func() error) error {
+	c.deposit()
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if !isStoreFailure(err) || !c.options.Retryable(err) {
+			return err
+		}
+		if attempt >= c.options.MaxAttempts || !c.withdraw() {
+			return err
+		}
+
+		c.sleep(c.backoff(attempt))
+	}
+}
+
+// backoff returns the wait before retry number attempt
+func (c *Retrying) backoff(attempt int) time.Duration {
+	var d = c.options.MinBackoff
+	for i := 1; i < attempt && d < c.options.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.options.MaxBackoff {
+		d = c.options.MaxBackoff
+	}
+
+	if c.options.Jitter > 0 {
+		d -= time.Duration(float64(d) * c.options.Jitter * c.random())
+	}
+	return d
+}
+
+// deposit earns BudgetRatio retries for an operation
+func (c *Retrying) deposit() {
+	if c.options.BudgetRatio < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tokens += c.options.BudgetRatio
+	if burst := float64(c.options.BudgetBurst); c.tokens > burst {
+		c.tokens = burst
+	}
+}
+
+// withdraw spends a retry, it reports false once the budget is spent
+func (c *Retrying) withdraw() bool {
+	if c.options.BudgetRatio < 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tokens < 1 {
+		return false
+	}
+	c.tokens--
+	return true
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyStore fails the next failures byte reads and writes
+type flakyStore struct {
+	*MemoryStore
+	failures int
+	calls    int
+}
+
+func (c *flakyStore) GetBytes(key string) ([]byte, error) {
+	c.calls++
+	if c.failures > 0 {
+		c.failures--
+		return nil, errUnavailable
+	}
+	return c.MemoryStore.GetBytes(key)
+}
+
+func (c *flakyStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) error {
+	c.calls++
+	if c.failures > 0 {
+		c.failures--
+		return errUnavailable
+	}
+	return c.MemoryStore.SetBytes(key, bytes, expiration...)
+}
+
+func newTestRetrying(store Cache, options RetryOptions) (*Retrying, *[]time.Duration) {
+	var sleeps []time.Duration
+	var retrying = NewRetrying(store, &options)
+	retrying.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	retrying.random = func() float64 { return 0.5 }
+	return retrying, &sleeps
+}
+
+func TestRetrying(t *testing.T) {
+	var store = &flakyStore{MemoryStore: NewMemoryStore(MemoryStoreOptions{})}
+	var retrying, sleeps = newTestRetrying(store, RetryOptions{
+		MaxAttempts: 4,
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  25 * time.Millisecond,
+		BudgetRatio: -1,
+	})
+
+	var strIn = "Hello world"
+	store.failures = 3
+	assert.NoError(t, retrying.Set("test_retry", &strIn))
+	assert.Equal(t, 4, store.calls)
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond}, *sleeps)
+
+	var strOut string
+	store.calls, store.failures = 0, 4
+	assert.Equal(t, errUnavailable, retrying.Get("test_retry", &strOut))
+	assert.Equal(t, 4, store.calls)
+
+	assert.NoError(t, retrying.Get("test_retry", &strOut))
+	assert.Equal(t, strIn, strOut)
+
+	// Misses and values that cannot be decoded are read once
+	store.calls = 0
+	assert.Equal(t, ErrKeyNotFound, retrying.Get("test_retry_missing", &strOut))
+	var intOut int
+	assert.Equal(t, ErrUnmarshal, retrying.Get("test_retry", &intOut))
+	assert.Equal(t, 2, store.calls)
+}
+
+func TestRetryingJitterAndClassifier(t *testing.T) {
+	var store = &flakyStore{MemoryStore: NewMemoryStore(MemoryStoreOptions{})}
+	var retrying, sleeps = newTestRetrying(store, RetryOptions{
+		MaxAttempts: 2,
+		MinBackoff:  10 * time.Millisecond,
+		Jitter:      0.5,
+		Retryable: func(err error) bool {
+			return err != errUnavailable
+		},
+	})
+
+	store.failures = 1
+	_, err := retrying.GetBytes("test_retry")
+	assert.Equal(t, errUnavailable, err)
+	assert.Equal(t, 1, store.calls)
+
+	retrying.options.Retryable = IsRetryable
+	store.calls, store.failures = 0, 1
+	_, err = retrying.GetBytes("test_retry")
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, store.calls)
+	assert.Equal(t, []time.Duration{7500 * time.Microsecond}, *sleeps)
+}
+
+func TestRetryingBudget(t *testing.T) {
+	var store = &flakyStore{MemoryStore: NewMemoryStore(MemoryStoreOptions{})}
+	var retrying, _ = newTestRetrying(store, RetryOptions{
+		MaxAttempts: 3,
+		BudgetRatio: 0.5,
+		BudgetBurst: 2,
+	})
+
+	// The burst is spent by the first operation
+	store.failures = 100
+	_, err := retrying.GetBytes("test_retry")
+	assert.Equal(t, errUnavailable, err)
+	assert.Equal(t, 3, store.calls)
+
+	// Every operation earns half a retry
+	store.calls = 0
+	retrying.GetBytes("test_retry")
+	retrying.GetBytes("test_retry")
+	assert.Equal(t, 3, store.calls)
+}
+
+func TestRetryingWrapper(t *testing.T) {
+	var writes, gets int
+	var writer = WriterFuncs{WriteFunc: func(key string, value interface{}) error {
+		writes++
+		if writes == 1 {
+			return errUnavailable
+		}
+		return nil
+	}}
+	var cache = Wrap(NewWriteThrough(writer, NewMemoryStore(MemoryStoreOptions{})), Middleware{
+		AfterGet: func(key string, value interface{}, err error) error {
+			gets++
+			return err
+		},
+	})
+	var retrying, _ = newTestRetrying(cache, RetryOptions{BudgetRatio: -1})
+
+	// The writer and the middleware see the values, the failed write is retried
+	var strIn = "Hello world"
+	assert.NoError(t, retrying.Set("test_retry_wrapper", &strIn))
+	assert.Equal(t, 2, writes)
+
+	var strOut string
+	assert.NoError(t, retrying.Get("test_retry_wrapper", &strOut))
+	assert.Equal(t, strIn, strOut)
+	assert.Equal(t, 1, gets)
+}
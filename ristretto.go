@@ -1,16 +1,18 @@
 package cache
 
 import (
+	"context"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
 	"github.com/pkg/errors"
-	"github.com/vmihailenco/msgpack/v5"
 )
 
 type RistrettoStore struct {
 	client            *ristretto.Cache
 	cost              int64
+	log               storeLogger
+	codec             Codec
 	DefaultExpiration time.Duration
 }
 
@@ -19,6 +21,15 @@ type RistrettoStoreOptions struct {
 	MaxCost     int64
 	BufferItems int64
 	DefaultCost int64
+
+	DefaultExpiration time.Duration
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes, evictions and failures
+	Events *Events
 }
 
 var RistrettoStoreOptionsDefault = &RistrettoStoreOptions{
@@ -28,20 +39,72 @@ var RistrettoStoreOptionsDefault = &RistrettoStoreOptions{
 	DefaultCost: 8,
 }
 
-func NewRistrettoStore(options *RistrettoStoreOptions) *RistrettoStore {
+// ristrettoEntry keeps the key next to the value, ristretto only passes the
+// key hash to OnEvict
+type ristrettoEntry struct {
+	key   string
+	value []byte
+}
+
+// NewRistrettoStore panics on invalid options, use OpenRistrettoStore to handle them
+func NewRistrettoStore(options *RistrettoStoreOptions, opts ...Option) *RistrettoStore {
+	store, err := OpenRistrettoStore(options, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// OpenRistrettoStore validates options and creates the store, nil options are
+// RistrettoStoreOptionsDefault
+func OpenRistrettoStore(options *RistrettoStoreOptions, opts ...Option) (*RistrettoStore, error) {
+	if options == nil {
+		options = RistrettoStoreOptionsDefault
+	}
+
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+	}.apply("ristretto", opts)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case options.NumCounters <= 0:
+		return nil, invalidOptions("ristretto", "NumCounters must be positive, got %d", options.NumCounters)
+	case options.MaxCost <= 0:
+		return nil, invalidOptions("ristretto", "MaxCost must be positive, got %d", options.MaxCost)
+	case options.BufferItems <= 0:
+		return nil, invalidOptions("ristretto", "BufferItems must be positive, got %d", options.BufferItems)
+	case options.DefaultCost < 0:
+		return nil, invalidOptions("ristretto", "DefaultCost must not be negative, got %d", options.DefaultCost)
+	}
+
+	var log = newStoreLogger("ristretto", config.Logger, config.SlowThreshold, config.Events)
+
 	client, err := ristretto.NewCache(&ristretto.Config{
 		NumCounters: options.NumCounters,
 		MaxCost:     options.MaxCost,
 		BufferItems: options.BufferItems,
+		OnEvict: func(item *ristretto.Item) {
+			if entry, ok := item.Value.(*ristrettoEntry); ok {
+				log.events.fireEvict(entry.key)
+			}
+		},
 	})
 	if err != nil {
-		panic(err)
+		return nil, invalidOptions("ristretto", "%v", err)
 	}
 
 	return &RistrettoStore{
-		client: client,
-		cost:   options.DefaultCost,
-	}
+		client:            client,
+		cost:              options.DefaultCost,
+		log:               log,
+		codec:             config.Codec,
+		DefaultExpiration: config.DefaultExpiration,
+	}, nil
 }
 
 func (c *RistrettoStore) Get(key string, value interface{}) error {
@@ -49,21 +112,32 @@ func (c *RistrettoStore) Get(key string, value interface{}) error {
 		return ErrMustBePointer
 	}
 
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+func (c *RistrettoStore) GetBytes(key string) (_ []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
 	val, found := c.client.Get(key)
 	if !found {
-		return ErrKeyNotFound
+		return nil, ErrKeyNotFound
 	}
 
-	var err error
-	switch v := val.(type) {
-	case []byte:
-		err = msgpack.Unmarshal(v, value)
-	case string:
-		err = msgpack.Unmarshal([]byte(v), value)
-
+	entry, ok := val.(*ristrettoEntry)
+	if !ok {
+		return nil, ErrUnmarshal
 	}
 
-	return err
+	return append([]byte(nil), entry.value...), nil
 }
 
 func (c *RistrettoStore) Set(key string, value interface{}, expiration ...time.Duration) error {
@@ -71,12 +145,25 @@ func (c *RistrettoStore) Set(key string, value interface{}, expiration ...time.D
 		return ErrMustBePointer
 	}
 
-	bytes, err := msgpack.Marshal(value)
+	bytes, err := c.codec.Marshal(value)
 	if err != nil {
 		return errors.Wrap(err, "Marshal error")
 	}
 
-	var success = c.client.Set(key, string(bytes), c.getCost())
+	return c.SetBytes(key, bytes, expiration...)
+}
+
+// SetBytes stores bytes as is, bypassing the codec
+func (c *RistrettoStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	var entry = &ristrettoEntry{key: key, value: append([]byte(nil), bytes...)}
+	var success = c.client.SetWithTTL(key, entry, c.getCost(), exp)
 	if !success {
 		return ErrRistrettoWrite
 	}
@@ -89,6 +176,66 @@ func (c *RistrettoStore) Delete(key string) error {
 	return nil
 }
 
+func (c *RistrettoStore) Exists(key string) (bool, error) {
+	_, found := c.client.Get(key)
+	return found, nil
+}
+
+func (c *RistrettoStore) TTL(key string) (time.Duration, error) {
+	ttl, found := c.client.GetTTL(key)
+	if !found {
+		return 0, ErrKeyNotFound
+	}
+
+	return ttl, nil
+}
+
+// Touch re-sets the stored bytes with the new lifetime, the value is not re-encoded
+func (c *RistrettoStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	val, found := c.client.Get(key)
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	if ttl < 0 {
+		ttl = NoExpiration
+	}
+
+	var success = c.client.SetWithTTL(key, val, c.getCost(), ttl)
+	if !success {
+		return ErrRistrettoWrite
+	}
+	return nil
+}
+
+func (c *RistrettoStore) Clear() error {
+	c.client.Clear()
+	return nil
+}
+
+// Scan is not supported, ristretto only keeps key hashes
+func (c *RistrettoStore) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	return nil, "", ErrNotSupported
+}
+
+// Ping always succeeds, the store is in process
+func (c *RistrettoStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close stops the goroutines of ristretto, the store must not be used afterwards
+func (c *RistrettoStore) Close() error {
+	c.client.Close()
+	return nil
+}
+
+// Codec returns the codec of values
+func (c *RistrettoStore) Codec() Codec {
+	return c.codec
+}
+
 func (c *RistrettoStore) Type() string {
 	return "ristretto"
 }
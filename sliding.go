@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"time"
+)
+
+// SlidingExpiration extends the lifetime of a key every time it is read, so
+// entries only expire after ttl without access
+type SlidingExpiration struct {
+	Cache
+	ttl time.Duration
+}
+
+func NewSlidingExpiration(cache Cache, ttl time.Duration) *SlidingExpiration {
+	return &SlidingExpiration{
+		Cache: cache,
+		ttl:   ttl,
+	}
+}
+
+// Unwrap returns the wrapped cache
+func (c *SlidingExpiration) Unwrap() Cache {
+	return c.Cache
+}
+
+// Get value by give key and touch it on success
+func (c *SlidingExpiration) Get(key string, value interface{}) error {
+	var err = c.Cache.Get(key, value)
+	if err != nil {
+		return err
+	}
+
+	c.touch(key)
+	return nil
+}
+
+// GetBytes by give key and touch it on success
+func (c *SlidingExpiration) GetBytes(key string) ([]byte, error) {
+	bytes, err := c.Cache.GetBytes(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.touch(key)
+	return bytes, nil
+}
+
+// Set value by give key, the sliding ttl is used unless an expiration is given
+func (c *SlidingExpiration) Set(key string, value interface{}, expiration ...time.Duration) error {
+	if len(expiration) == 0 {
+		expiration = []time.Duration{c.ttl}
+	}
+
+	return c.Cache.Set(key, value, expiration...)
+}
+
+// SetBytes by give key, the sliding ttl is used unless an expiration is given
+func (c *SlidingExpiration) SetBytes(key string, bytes []byte, expiration ...time.Duration) error {
+	if len(expiration) == 0 {
+		expiration = []time.Duration{c.ttl}
+	}
+
+	return c.Cache.SetBytes(key, bytes, expiration...)
+}
+
+// touch slides the lifetime of key, a failure does not fail the read and is logged
+func (c *SlidingExpiration) touch(key string) {
+	if err := c.Cache.Touch(key, c.ttl); err != nil && !isExpected(err) {
+		DefaultLogger.Warn("cache: ignored failure", "store", c.Cache.Type(), "op", "touch", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingExpiration(t *testing.T) {
+	var store = NewMemoryStore(MemoryStoreOptions{})
+	var sliding Cache = NewSlidingExpiration(store, time.Hour)
+
+	var key = "test_sliding"
+	var strIn = "Hello world"
+	var err = sliding.Set(key, &strIn)
+	assert.NoError(t, err)
+
+	ttl, err := store.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Hour-time.Minute)
+
+	// Shorten the lifetime, a read slides it back
+	assert.NoError(t, store.Touch(key, time.Minute))
+
+	var strOut string
+	assert.NoError(t, sliding.Get(key, &strOut))
+	assert.Equal(t, strIn, strOut)
+
+	ttl, err = store.TTL(key)
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Hour-time.Minute)
+
+	assert.Equal(t, ErrKeyNotFound, sliding.Touch("test_sliding_missing", time.Hour))
+}
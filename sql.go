@@ -0,0 +1,560 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SQLDialect selects the SQL syntax of a SQLStore
+type SQLDialect string
+
+const (
+	DialectPostgres SQLDialect = "postgres"
+	DialectMySQL    SQLDialect = "mysql"
+	DialectSQLite   SQLDialect = "sqlite"
+)
+
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLStore keeps entries in a table of a relational database, the same way
+// MongoDBStore keeps them in a collection: the expiration is a column, expired
+// rows are hidden from reads and deleted when met or by the periodic purge
+type SQLStore struct {
+	client            *sql.DB
+	ownsClient        bool
+	dialect           SQLDialect
+	queries           sqlQueries
+	timeouts          Timeouts
+	log               storeLogger
+	codec             Codec
+	stop              chan struct{}
+	closeOnce         sync.Once
+	DefaultExpiration time.Duration
+}
+
+type SQLStoreOptions struct {
+	// DB is an open database, Close leaves it open
+	DB *sql.DB
+	// DriverName and DataSourceName open the database when DB is nil, the
+	// driver must be registered by the caller
+	DriverName     string
+	DataSourceName string
+	// Dialect is required with DB, otherwise it defaults to the one of DriverName
+	Dialect SQLDialect
+	// Table is created if missing, default is "caches"
+	Table string
+
+	// PurgeInterval is the period of the deletion of expired rows.
+	// Default is 1 minute; -1 disables it, expired rows are then deleted when read.
+	PurgeInterval time.Duration
+
+	DefaultExpiration time.Duration
+
+	// Logger receives failed and slow operations, default is DefaultLogger
+	Logger Logger
+	// SlowThreshold reports operations slower than it, 0 disables it
+	SlowThreshold time.Duration
+	// Events are fired on hits, misses, writes and failures
+	Events *Events
+
+	// Timeouts bound reads and writes, default is TimeoutsDefault.
+	// Operations running out of time return ErrTimeout.
+	Timeouts Timeouts
+}
+
+var SQLStoreOptionsDefault = &SQLStoreOptions{
+	Table:         "caches",
+	PurgeInterval: time.Minute,
+}
+
+// sqlDrivers maps the usual driver names to their dialect
+var sqlDrivers = map[string]SQLDialect{
+	"postgres": DialectPostgres,
+	"pgx":      DialectPostgres,
+	"mysql":    DialectMySQL,
+	"sqlite":   DialectSQLite,
+	"sqlite3":  DialectSQLite,
+}
+
+// NewSQLStore panics on invalid options or when the table cannot be created,
+// use OpenSQLStore to handle them
+func NewSQLStore(options SQLStoreOptions, opts ...Option) *SQLStore {
+	store, err := OpenSQLStore(options, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// OpenSQLStore validates options, opens the database when needed and creates
+// the table and its expiration index within the write timeout
+func OpenSQLStore(options SQLStoreOptions, opts ...Option) (*SQLStore, error) {
+	config, err := storeConfig{
+		DefaultExpiration: options.DefaultExpiration,
+		Logger:            options.Logger,
+		SlowThreshold:     options.SlowThreshold,
+		Events:            options.Events,
+		Timeouts:          options.Timeouts,
+	}.apply("sql", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialect = options.Dialect
+	if dialect == "" {
+		dialect = sqlDrivers[options.DriverName]
+	}
+	var table = options.Table
+	if table == "" {
+		table = SQLStoreOptionsDefault.Table
+	}
+	switch {
+	case options.DB == nil && options.DriverName == "":
+		return nil, invalidOptions("sql", "DB or DriverName is required")
+	case dialect == "":
+		return nil, invalidOptions("sql", "Dialect is required for driver %q", options.DriverName)
+	case dialect != DialectPostgres && dialect != DialectMySQL && dialect != DialectSQLite:
+		return nil, invalidOptions("sql", "unknown Dialect %q", dialect)
+	case !sqlTableName.MatchString(table):
+		return nil, invalidOptions("sql", "Table must be a plain identifier, got %q", table)
+	}
+
+	var client = options.DB
+	if client == nil {
+		client, err = sql.Open(options.DriverName, options.DataSourceName)
+		if err != nil {
+			return nil, invalidOptions("sql", "%v", err)
+		}
+	}
+
+	var store = &SQLStore{
+		client:            client,
+		ownsClient:        options.DB == nil,
+		dialect:           dialect,
+		queries:           newSQLQueries(dialect, table),
+		timeouts:          config.Timeouts.orDefault(TimeoutsDefault),
+		log:               newStoreLogger("sql", config.Logger, config.SlowThreshold, config.Events),
+		codec:             config.Codec,
+		stop:              make(chan struct{}),
+		DefaultExpiration: config.DefaultExpiration,
+	}
+
+	if err := store.migrate(); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	var interval = options.PurgeInterval
+	if interval == 0 {
+		interval = SQLStoreOptionsDefault.PurgeInterval
+	}
+	if interval > 0 {
+		go store.purger(interval)
+	}
+
+	return store, nil
+}
+
+// sqlQueries are the statements of a store, built once for its dialect and table
+type sqlQueries struct {
+	create []string
+	get    string
+	upsert string
+	delete string
+	expire string
+	exists string
+	touch  string
+	clear  string
+	scan   string
+	purge  string
+
+	// maxKey is the length in bytes of the longest key the table holds, 0 is unlimited
+	maxKey int
+}
+
+func newSQLQueries(dialect SQLDialect, table string) sqlQueries {
+	// Keys are compared bytewise so Scan pages in the same order on every database
+	var create []string
+	var maxKey int
+	switch dialect {
+	case DialectPostgres:
+		create = []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (cache_key TEXT COLLATE "C" PRIMARY KEY, value BYTEA NOT NULL, expired_at BIGINT NOT NULL DEFAULT 0)`,
+			`CREATE INDEX IF NOT EXISTS ` + table + `_expired_at ON ` + table + ` (expired_at)`,
+		}
+	case DialectMySQL:
+		// MySQL has no CREATE INDEX IF NOT EXISTS, the index is declared with the
+		// table. A longer key would be truncated or fail depending on the SQL mode
+		maxKey = 255
+		create = []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (cache_key VARBINARY(255) PRIMARY KEY, value LONGBLOB NOT NULL, expired_at BIGINT NOT NULL DEFAULT 0, INDEX ` + table + `_expired_at (expired_at))`,
+		}
+	default:
+		create = []string{
+			`CREATE TABLE IF NOT EXISTS ` + table + ` (cache_key TEXT PRIMARY KEY, value BLOB NOT NULL, expired_at BIGINT NOT NULL DEFAULT 0)`,
+			`CREATE INDEX IF NOT EXISTS ` + table + `_expired_at ON ` + table + ` (expired_at)`,
+		}
+	}
+
+	var upsert = `INSERT INTO ` + table + ` (cache_key, value, expired_at) VALUES (?, ?, ?) `
+	if dialect == DialectMySQL {
+		upsert += `ON DUPLICATE KEY UPDATE value = VALUES(value), expired_at = VALUES(expired_at)`
+	} else {
+		upsert += `ON CONFLICT (cache_key) DO UPDATE SET value = excluded.value, expired_at = excluded.expired_at`
+	}
+
+	const alive = `(expired_at = 0 OR expired_at > ?)`
+	var queries = sqlQueries{
+		create: create,
+		get:    `SELECT value, expired_at FROM ` + table + ` WHERE cache_key = ?`,
+		upsert: upsert,
+		delete: `DELETE FROM ` + table + ` WHERE cache_key = ?`,
+		expire: `DELETE FROM ` + table + ` WHERE cache_key = ? AND expired_at > 0 AND expired_at <= ?`,
+		exists: `SELECT COUNT(*) FROM ` + table + ` WHERE cache_key = ? AND ` + alive,
+		touch:  `UPDATE ` + table + ` SET expired_at = ? WHERE cache_key = ? AND ` + alive,
+		clear:  `DELETE FROM ` + table,
+		scan:   `SELECT cache_key FROM ` + table + ` WHERE cache_key > ? AND ` + alive + ` ORDER BY cache_key LIMIT ?`,
+		purge:  `DELETE FROM ` + table + ` WHERE expired_at > 0 AND expired_at <= ?`,
+		maxKey: maxKey,
+	}
+	if dialect == DialectPostgres {
+		for _, q := range []*string{&queries.get, &queries.upsert, &queries.delete, &queries.expire,
+			&queries.exists, &queries.touch, &queries.scan, &queries.purge} {
+			*q = numberPlaceholders(*q)
+		}
+	}
+	return queries
+}
+
+// numberPlaceholders rewrites ? placeholders as $1, $2, ... for PostgreSQL
+func numberPlaceholders(query string) string {
+	var b strings.Builder
+	var n int
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (c *SQLStore) migrate() error {
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	for _, query := range c.queries.create {
+		if _, err := c.client.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *SQLStore) Get(key string, value interface{}) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.GetBytes(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.codec.Unmarshal(bytes, value); err != nil {
+		c.log.failed("get", key, err)
+		return ErrUnmarshal
+	}
+	return nil
+}
+
+func (c *SQLStore) GetBytes(key string) (_ []byte, err error) {
+	defer c.log.track("get", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	value, _, err := c.find(ctx, key)
+	return value, err
+}
+
+// find loads the row of key, an expired row is deleted and reported as ErrKeyNotFound
+func (c *SQLStore) find(ctx context.Context, key string) ([]byte, int64, error) {
+	var value []byte
+	var expiredAt int64
+	err := c.client.QueryRowContext(ctx, c.queries.get, key).Scan(&value, &expiredAt)
+	if err == sql.ErrNoRows {
+		return nil, 0, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var now = sqlNow()
+	if expiredAt > 0 && expiredAt <= now {
+		if _, err := c.client.ExecContext(ctx, c.queries.expire, key, now); err != nil {
+			return nil, 0, err
+		}
+		return nil, 0, ErrKeyNotFound
+	}
+
+	return value, expiredAt, nil
+}
+
+func (c *SQLStore) Set(key string, value interface{}, expiration ...time.Duration) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	bytes, err := c.codec.Marshal(value)
+	if err != nil {
+		return ErrMarshal
+	}
+
+	return c.SetBytes(key, bytes, expiration...)
+}
+
+// SetBytes upserts the row with the dialect's ON CONFLICT or ON DUPLICATE KEY
+// clause. Keys longer than 255 bytes return ErrKeyTooLong on MySQL
+func (c *SQLStore) SetBytes(key string, bytes []byte, expiration ...time.Duration) (err error) {
+	defer c.log.track("set", key, time.Now(), &err)
+
+	if c.queries.maxKey > 0 && len(key) > c.queries.maxKey {
+		return ErrKeyTooLong
+	}
+
+	var exp = c.DefaultExpiration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	// A nil slice is stored as NULL, which the value column rejects
+	if bytes == nil {
+		bytes = []byte{}
+	}
+	_, err = c.client.ExecContext(ctx, c.queries.upsert, key, bytes, sqlExpiredAt(exp))
+	return err
+}
+
+func (c *SQLStore) Delete(key string) (err error) {
+	defer c.log.track("delete", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	_, err = c.client.ExecContext(ctx, c.queries.delete, key)
+	return err
+}
+
+func (c *SQLStore) Exists(key string) (_ bool, err error) {
+	defer c.log.track("exists", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	return c.exists(ctx, key)
+}
+
+func (c *SQLStore) exists(ctx context.Context, key string) (bool, error) {
+	var n int
+	if err := c.client.QueryRowContext(ctx, c.queries.exists, key, sqlNow()).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (c *SQLStore) TTL(key string) (_ time.Duration, err error) {
+	defer c.log.track("ttl", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(context.Background())
+	defer cancel()
+
+	_, expiredAt, err := c.find(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if expiredAt == 0 {
+		return NoExpiration, nil
+	}
+	return time.Until(time.UnixMilli(expiredAt)), nil
+}
+
+func (c *SQLStore) Touch(key string, ttl time.Duration) (err error) {
+	defer c.log.track("touch", key, time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	result, err := c.client.ExecContext(ctx, c.queries.touch, sqlExpiredAt(ttl), key, sqlNow())
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// MySQL does not count rows left unchanged, the row may still be there
+	found, err := c.exists(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// Clear deletes every row of the table, the table and its index are kept
+func (c *SQLStore) Clear() (err error) {
+	defer c.log.track("clear", "", time.Now(), &err)
+
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	_, err = c.client.ExecContext(ctx, c.queries.clear)
+	return err
+}
+
+// Scan reads the keys in order by pages of count and matches them against the
+// pattern, the cursor is the last key returned
+func (c *SQLStore) Scan(ctx context.Context, pattern string, cursor string, count int) (_ []string, _ string, err error) {
+	defer c.log.track("scan", pattern, time.Now(), &err)
+
+	re, err := regexp.Compile(globToRegexp(pattern))
+	if err != nil {
+		return nil, "", err
+	}
+	count = scanCount(count)
+
+	ctx, cancel := c.timeouts.read(ctx)
+	defer cancel()
+
+	var keys []string
+	var after = cursor
+	for {
+		page, err := c.scanPage(ctx, after, count)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, key := range page {
+			if !re.MatchString(key) {
+				continue
+			}
+			if len(keys) == count {
+				return keys, keys[count-1], nil
+			}
+			keys = append(keys, key)
+		}
+
+		if len(page) < count {
+			return keys, "", nil
+		}
+		after = page[len(page)-1]
+	}
+}
+
+func (c *SQLStore) scanPage(ctx context.Context, after string, count int) ([]string, error) {
+	rows, err := c.client.QueryContext(ctx, c.queries.scan, after, sqlNow(), count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Ping checks the database within the read timeout
+func (c *SQLStore) Ping(ctx context.Context) (err error) {
+	defer c.log.track("ping", "", time.Now(), &err)
+
+	ctx, cancel := c.timeouts.read(ctx)
+	defer cancel()
+
+	return c.client.PingContext(ctx)
+}
+
+// Close stops the purge, the database is closed only when the store opened it
+func (c *SQLStore) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		if c.ownsClient {
+			err = c.client.Close()
+		}
+	})
+	return err
+}
+
+// Codec returns the codec of values
+func (c *SQLStore) Codec() Codec {
+	return c.codec
+}
+
+func (c *SQLStore) Type() string {
+	return "sql"
+}
+
+// Dialect returns the SQL syntax used by the store
+func (c *SQLStore) Dialect() SQLDialect {
+	return c.dialect
+}
+
+// purger deletes expired rows every interval until the store is closed
+func (c *SQLStore) purger(interval time.Duration) {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.log.swallowed("purge", "", c.purge())
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// purge deletes the expired rows through the expiration index
+func (c *SQLStore) purge() error {
+	ctx, cancel := c.timeouts.write(context.Background())
+	defer cancel()
+
+	_, err := c.client.ExecContext(ctx, c.queries.purge, sqlNow())
+	return err
+}
+
+// sqlNow is the current time in the unit of the expired_at column, unix milliseconds
+func sqlNow() int64 {
+	return time.Now().UnixMilli()
+}
+
+func sqlExpiredAt(expiration time.Duration) int64 {
+	if expiration <= 0 {
+		return 0
+	}
+	return time.Now().Add(expiration).UnixMilli()
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLStoreExpiration(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var store = NewSQLStore(SQLStoreOptions{DB: db, Dialect: DialectSQLite, PurgeInterval: -1})
+	defer store.Close()
+
+	var strIn = "Hello world"
+	assert.NoError(t, store.Set("test_expire_read", &strIn, 100*time.Millisecond))
+	assert.NoError(t, store.Set("test_expire_purge", &strIn, 100*time.Millisecond))
+	assert.NoError(t, store.Set("test_expire_touched", &strIn, 100*time.Millisecond))
+	assert.NoError(t, store.Touch("test_expire_touched", 0))
+	time.Sleep(150 * time.Millisecond)
+
+	var strOut string
+	assert.Equal(t, ErrKeyNotFound, store.Get("test_expire_read", &strOut))
+	assert.Equal(t, ErrKeyNotFound, store.Touch("test_expire_purge", time.Minute))
+
+	var rows int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM caches").Scan(&rows))
+	assert.Equal(t, 2, rows)
+
+	assert.NoError(t, store.purge())
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM caches").Scan(&rows))
+	assert.Equal(t, 1, rows)
+
+	ttl, err := store.TTL("test_expire_touched")
+	assert.NoError(t, err)
+	assert.Equal(t, NoExpiration, ttl)
+
+	// The database passed in is left open
+	assert.NoError(t, store.Close())
+	assert.NoError(t, db.Ping())
+}
+
+func TestSQLStoreScan(t *testing.T) {
+	var store = NewSQLStore(SQLStoreOptions{
+		DriverName:     "sqlite3",
+		DataSourceName: filepath.Join(t.TempDir(), "cache.db"),
+		Table:          "scan_caches",
+	})
+	defer store.Close()
+
+	var strIn = "Hello world"
+	for _, key := range []string{"order:1", "user:1", "order:2", "user:2", "order:3", "user:3"} {
+		assert.NoError(t, store.Set(key, &strIn))
+	}
+
+	keys, cursor, err := store.Scan(context.Background(), "user:*", "", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+	assert.Equal(t, "user:2", cursor)
+
+	keys, cursor, err = store.Scan(context.Background(), "user:*", cursor, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:3"}, keys)
+	assert.Equal(t, "", cursor)
+}
+
+func TestSQLQueries(t *testing.T) {
+	var queries = newSQLQueries(DialectPostgres, "caches")
+	assert.Equal(t, "DELETE FROM caches WHERE cache_key = $1 AND expired_at > 0 AND expired_at <= $2", queries.expire)
+	assert.Contains(t, queries.upsert, "VALUES ($1, $2, $3) ON CONFLICT (cache_key) DO UPDATE")
+
+	queries = newSQLQueries(DialectMySQL, "caches")
+	assert.Contains(t, queries.upsert, "VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE")
+	assert.Len(t, queries.create, 1)
+	assert.Equal(t, 255, queries.maxKey)
+
+	// Keys longer than the key column are rejected instead of truncated
+	var store = NewSQLStore(SQLStoreOptions{DriverName: "sqlite3", DataSourceName: filepath.Join(t.TempDir(), "cache.db")})
+	defer store.Close()
+	store.queries.maxKey = 8
+	assert.Equal(t, ErrKeyTooLong, store.SetBytes("test_key_too_long", []byte("value")))
+	assert.NoError(t, store.SetBytes("test_key", []byte("value")))
+
+	_, err := OpenSQLStore(SQLStoreOptions{DriverName: "sqlite3", Table: "caches; DROP TABLE users"})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = OpenSQLStore(SQLStoreOptions{DB: &sql.DB{}})
+	assert.EqualError(t, err, `cache: Invalid options: sql: Dialect is required for driver ""`)
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Timeouts bound the operations of a remote store
+type Timeouts struct {
+	// Read bounds Get, GetBytes, Exists, TTL, Scan and GetCAS
+	Read time.Duration
+	// Write bounds Set, SetBytes, Delete, Touch, Clear, counters and conditional writes
+	Write time.Duration
+}
+
+// TimeoutsDefault is used for the timeouts left at zero
+var TimeoutsDefault = Timeouts{
+	Read:  30 * time.Second,
+	Write: 30 * time.Second,
+}
+
+// orDefault fills the timeouts left at zero from d
+func (t Timeouts) orDefault(d Timeouts) Timeouts {
+	if t.Read <= 0 {
+		t.Read = d.Read
+	}
+	if t.Write <= 0 {
+		t.Write = d.Write
+	}
+	return t
+}
+
+func (t Timeouts) read(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, t.Read)
+}
+
+func (t Timeouts) write(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, t.Write)
+}
+
+// isTimeout reports whether err is a deadline or a network timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
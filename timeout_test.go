@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stallingStore blocks the first read until release is closed
+type stallingStore struct {
+	*MemoryStore
+	release chan struct{}
+	calls   int32
+}
+
+func (c *stallingStore) GetBytes(key string) ([]byte, error) {
+	if atomic.AddInt32(&c.calls, 1) == 1 {
+		<-c.release
+	}
+	return c.MemoryStore.GetBytes(key)
+}
+
+func TestHedged(t *testing.T) {
+	var store = &stallingStore{MemoryStore: NewMemoryStore(MemoryStoreOptions{}), release: make(chan struct{})}
+	defer close(store.release)
+	var hedged = NewHedged(store, 10*time.Millisecond)
+
+	var strIn = "Hello world"
+	assert.NoError(t, hedged.Set("test_hedged", &strIn))
+
+	var strOut string
+	assert.NoError(t, hedged.Get("test_hedged", &strOut))
+	assert.Equal(t, strIn, strOut)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&store.calls))
+
+	// Fast reads are not doubled
+	assert.Equal(t, ErrKeyNotFound, hedged.Get("test_hedged_missing", &strOut))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&store.calls))
+}
+
+func TestHedgedWrapper(t *testing.T) {
+	var release = make(chan struct{})
+	defer close(release)
+	var calls int32
+	var cache = Wrap(NewMemoryStore(MemoryStoreOptions{}), Middleware{
+		BeforeGet: func(key string) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-release
+			}
+			return nil
+		},
+	})
+	var hedged = NewHedged(cache, 10*time.Millisecond)
+
+	// The middleware sees both reads, the stalled one never writes to strOut
+	var strIn = "Hello world"
+	assert.NoError(t, hedged.Set("test_hedged_wrapper", &strIn))
+
+	var strOut string
+	assert.NoError(t, hedged.Get("test_hedged_wrapper", &strOut))
+	assert.Equal(t, strIn, strOut)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestTimeoutErrors(t *testing.T) {
+	var log = newStoreLogger("test", NopLogger{}, 0, nil)
+
+	var track = func(err error) error {
+		log.track("get", "test_timeout", time.Now(), &err)
+		return err
+	}
+	assert.Equal(t, ErrTimeout, track(context.DeadlineExceeded))
+	assert.Equal(t, ErrTimeout, track(fmt.Errorf("read: %w", context.DeadlineExceeded)))
+	assert.Equal(t, ErrKeyNotFound, track(ErrKeyNotFound))
+	assert.Equal(t, context.Canceled, track(context.Canceled))
+
+	var timeouts = Timeouts{Write: time.Second}.orDefault(TimeoutsDefault)
+	assert.Equal(t, Timeouts{Read: 30 * time.Second, Write: time.Second}, timeouts)
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attributes set by Traced
+const (
+	AttributeStore       = attribute.Key("cache.store")
+	AttributeKey         = attribute.Key("cache.key")
+	AttributeKeyHash     = attribute.Key("cache.key_hash")
+	AttributeHit         = attribute.Key("cache.hit")
+	AttributePayloadSize = attribute.Key("cache.payload_size")
+)
+
+type TracingOptions struct {
+	// HashKeys records a SHA-256 prefix of the key instead of the key itself,
+	// for keys carrying personal data
+	HashKeys bool
+}
+
+// Traced creates a span for every operation of the wrapped cache. The Cache
+// interface carries no context, bind the parent span with WithContext.
+// When the wrapped cache is a Chain every tier gets a child span, which shows
+// the tier that served a request
+type Traced struct {
+	Cache
+	tracer   trace.Tracer
+	ctx      context.Context
+	hashKeys bool
+
+	chain *Chain
+	tiers []*Traced
+}
+
+func NewTraced(cache Cache, tracer trace.Tracer, options TracingOptions) *Traced {
+	var traced = &Traced{
+		Cache:    cache,
+		tracer:   tracer,
+		ctx:      context.Background(),
+		hashKeys: options.HashKeys,
+	}
+
+	if chain, ok := cache.(*Chain); ok {
+		traced.chain = chain
+		for _, tier := range chain.caches {
+			traced.tiers = append(traced.tiers, NewTraced(tier, tracer, options))
+		}
+	}
+
+	return traced
+}
+
+// Unwrap returns the wrapped cache
+func (c *Traced) Unwrap() Cache {
+	return c.Cache
+}
+
+// WithContext returns a copy whose spans are children of the span in ctx
+func (c *Traced) WithContext(ctx context.Context) *Traced {
+	var traced = *c
+	traced.ctx = ctx
+	return &traced
+}
+
+// Get goes through GetBytes of a single store to record the payload size, as
+// Instrumented does
+func (c *Traced) Get(key string, value interface{}) error {
+	ctx, span := c.start(c.ctx, "get", key)
+	size, err := getValue(c.target(ctx), key, value)
+	if size >= 0 {
+		span.SetAttributes(AttributePayloadSize.Int(size))
+	}
+	endRead(span, err)
+	return err
+}
+
+func (c *Traced) Set(key string, value interface{}, expiration ...time.Duration) error {
+	ctx, span := c.start(c.ctx, "set", key)
+	size, err := setValue(c.target(ctx), key, value, expiration...)
+	if size >= 0 {
+		span.SetAttributes(AttributePayloadSize.Int(size))
+	}
+	endWrite(span, err)
+	return err
+}
+
+func (c *Traced) Delete(key string) error {
+	ctx, span := c.start(c.ctx, "delete", key)
+	var err = c.target(ctx).Delete(key)
+	endWrite(span, err)
+	return err
+}
+
+func (c *Traced) GetBytes(key string) ([]byte, error) {
+	ctx, span := c.start(c.ctx, "get_bytes", key)
+	bytes, err := c.target(ctx).GetBytes(key)
+	if err == nil {
+		span.SetAttributes(AttributePayloadSize.Int(len(bytes)))
+	}
+	endRead(span, err)
+	return bytes, err
+}
+
+func (c *Traced) SetBytes(key string, bytes []byte, expiration ...time.Duration) error {
+	ctx, span := c.start(c.ctx, "set_bytes", key)
+	span.SetAttributes(AttributePayloadSize.Int(len(bytes)))
+	var err = c.target(ctx).SetBytes(key, bytes, expiration...)
+	endWrite(span, err)
+	return err
+}
+
+func (c *Traced) Exists(key string) (bool, error) {
+	ctx, span := c.start(c.ctx, "exists", key)
+	found, err := c.target(ctx).Exists(key)
+	if err == nil {
+		span.SetAttributes(AttributeHit.Bool(found))
+	}
+	endWrite(span, err)
+	return found, err
+}
+
+func (c *Traced) TTL(key string) (time.Duration, error) {
+	ctx, span := c.start(c.ctx, "ttl", key)
+	ttl, err := c.target(ctx).TTL(key)
+	endRead(span, err)
+	return ttl, err
+}
+
+func (c *Traced) Touch(key string, ttl time.Duration) error {
+	ctx, span := c.start(c.ctx, "touch", key)
+	var err = c.target(ctx).Touch(key, ttl)
+	endRead(span, err)
+	return err
+}
+
+func (c *Traced) Clear() error {
+	ctx, span := c.start(c.ctx, "clear", "")
+	var err = c.target(ctx).Clear()
+	endWrite(span, err)
+	return err
+}
+
+// Scan uses the span in ctx as parent rather than the bound context
+func (c *Traced) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	ctx, span := c.start(ctx, "scan", "")
+	span.SetAttributes(attribute.String("cache.pattern", pattern))
+	keys, next, err := c.target(ctx).Scan(ctx, pattern, cursor, count)
+	endWrite(span, err)
+	return keys, next, err
+}
+
+func (c *Traced) start(ctx context.Context, name string, key string) (context.Context, trace.Span) {
+	var attrs = []attribute.KeyValue{AttributeStore.String(c.Cache.Type())}
+	if key != "" {
+		if c.hashKeys {
+			var sum = sha256.Sum256([]byte(key))
+			attrs = append(attrs, AttributeKeyHash.String(hex.EncodeToString(sum[:8])))
+		} else {
+			attrs = append(attrs, AttributeKey.String(key))
+		}
+	}
+
+	return c.tracer.Start(ctx, "cache."+name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// target is the cache to call, for a Chain its tiers are bound to the operation span
+func (c *Traced) target(ctx context.Context) Cache {
+	if c.chain == nil {
+		return c.Cache
+	}
+
+	var caches = make([]Cache, len(c.tiers))
+	for i, tier := range c.tiers {
+		caches[i] = tier.WithContext(ctx)
+	}
+	return c.chain.withCaches(caches)
+}
+
+// endRead ends a span of an operation where ErrKeyNotFound is a miss, not a failure
+func endRead(span trace.Span, err error) {
+	switch err {
+	case nil:
+		span.SetAttributes(AttributeHit.Bool(true))
+	case ErrKeyNotFound:
+		span.SetAttributes(AttributeHit.Bool(false))
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func endWrite(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraced(t *testing.T) {
+	var recorder = tracetest.NewSpanRecorder()
+	var provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	var tracer = provider.Tracer("cache")
+
+	var l1 = NewMemoryStore(MemoryStoreOptions{})
+	var l2 = NewMemoryStore(MemoryStoreOptions{})
+	var strIn = "Hello world"
+	assert.NoError(t, l2.Set("test_traced", &strIn))
+
+	ctx, parent := tracer.Start(context.Background(), "request")
+	var traced = NewTraced(NewChain(l1, l2), tracer, TracingOptions{HashKeys: true}).WithContext(ctx)
+
+	var strOut string
+	assert.NoError(t, traced.Get("test_traced", &strOut))
+	parent.End()
+
+	var spans = recorder.Ended()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	// Tier spans, the backfill write, the chain span and the request
+	assert.Equal(t, []string{"cache.get_bytes", "cache.get_bytes", "cache.ttl", "cache.set_bytes", "cache.get", "request"}, names)
+
+	var chainSpan = spans[4]
+	assert.Equal(t, parent.SpanContext().SpanID(), chainSpan.Parent().SpanID())
+	for _, span := range spans[:4] {
+		assert.Equal(t, chainSpan.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+
+	var attrs = map[string]string{}
+	for _, attr := range spans[1].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	assert.Equal(t, "memory", attrs["cache.store"])
+	assert.Equal(t, "true", attrs["cache.hit"])
+	assert.NotEmpty(t, attrs["cache.key_hash"])
+	assert.Empty(t, attrs["cache.key"])
+
+	attrs = map[string]string{}
+	for _, attr := range spans[0].Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	assert.Equal(t, "false", attrs["cache.hit"])
+
+	// Get and Set of a single store record the payload size
+	recorder = tracetest.NewSpanRecorder()
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("cache")
+	traced = NewTraced(NewMemoryStore(MemoryStoreOptions{}), tracer, TracingOptions{})
+	assert.NoError(t, traced.Set("test_traced", &strIn))
+	assert.NoError(t, traced.Get("test_traced", &strOut))
+
+	var encoded, _ = DefaultCodec.Marshal(&strIn)
+	assert.Len(t, recorder.Ended(), 2)
+	for _, span := range recorder.Ended() {
+		attrs = map[string]string{}
+		for _, attr := range span.Attributes() {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+		assert.Equal(t, strconv.Itoa(len(encoded)), attrs["cache.payload_size"], span.Name())
+	}
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"time"
+)
+
+// Typed is a type-safe facade over a Cache. Values are passed and returned
+// by value, the pointer handling required by Cache is done internally
+//
+//	var users = cache.NewTyped[User](store)
+//	err := users.Set("user:1", user, time.Hour)
+//	user, err := users.Get("user:1")
+type Typed[T any] struct {
+	cache Cache
+}
+
+func NewTyped[T any](cache Cache) *Typed[T] {
+	return &Typed[T]{
+		cache: cache,
+	}
+}
+
+// Get value by give key, the zero value is returned with the error
+func (c *Typed[T]) Get(key string) (T, error) {
+	var value T
+	if err := c.cache.Get(key, &value); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return value, nil
+}
+
+// Set value by give key
+func (c *Typed[T]) Set(key string, value T, expiration ...time.Duration) error {
+	return c.cache.Set(key, &value, expiration...)
+}
+
+// Delete by give key
+func (c *Typed[T]) Delete(key string) error {
+	return c.cache.Delete(key)
+}
+
+// GetOrLoad returns the cached value, or calls load when the cache cannot
+// serve it and stores the result. A failed Set still returns the loaded value
+// together with the error
+func (c *Typed[T]) GetOrLoad(key string, load func() (T, error), expiration ...time.Duration) (T, error) {
+	if value, err := c.Get(key); err == nil {
+		return value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return value, c.Set(key, value, expiration...)
+}
+
+// Cache returns the underlying cache
+func (c *Typed[T]) Cache() Cache {
+	return c.cache
+}
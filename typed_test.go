@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTyped(t *testing.T) {
+	var items = NewTyped[CacheItem](NewMemoryStore(MemoryStoreOptions{}))
+
+	_, err := items.Get("test_typed")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.NoError(t, items.Set("test_typed", CacheItem{Name: "Hello world"}))
+
+	item, err := items.Get("test_typed")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello world", item.Name)
+
+	var loads int
+	var load = func() (CacheItem, error) {
+		loads++
+		return CacheItem{Name: "Loaded"}, nil
+	}
+
+	item, err = items.GetOrLoad("test_typed_load", load)
+	assert.NoError(t, err)
+	assert.Equal(t, "Loaded", item.Name)
+
+	item, err = items.GetOrLoad("test_typed_load", load)
+	assert.NoError(t, err)
+	assert.Equal(t, "Loaded", item.Name)
+	assert.Equal(t, 1, loads)
+
+	var errLoad = errors.New("load failed")
+	_, err = items.GetOrLoad("test_typed_missing", func() (CacheItem, error) {
+		return CacheItem{}, errLoad
+	})
+	assert.Equal(t, errLoad, err)
+}
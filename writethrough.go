@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Writer persists values to the system of record behind a WriteThrough cache
+type Writer interface {
+	// Write persists value (a pointer, as passed to Set) under key
+	Write(key string, value interface{}) error
+
+	// Delete removes key from the system of record
+	Delete(key string) error
+}
+
+// WriterFuncs adapts a pair of functions to the Writer interface
+type WriterFuncs struct {
+	WriteFunc  func(key string, value interface{}) error
+	DeleteFunc func(key string) error
+}
+
+func (w WriterFuncs) Write(key string, value interface{}) error {
+	if w.WriteFunc == nil {
+		return nil
+	}
+	return w.WriteFunc(key, value)
+}
+
+func (w WriterFuncs) Delete(key string) error {
+	if w.DeleteFunc == nil {
+		return nil
+	}
+	return w.DeleteFunc(key)
+}
+
+// WriteThrough makes the cache the single write path for an entity: every
+// write goes to the system of record first and only then to the cache tiers
+type WriteThrough struct {
+	writer Writer
+	cache  Cache
+}
+
+// NewWriteThrough wraps cache, which may be a single store or a Chain
+func NewWriteThrough(writer Writer, cache Cache) *WriteThrough {
+	return &WriteThrough{
+		writer: writer,
+		cache:  cache,
+	}
+}
+
+// Unwrap returns the wrapped cache
+func (c *WriteThrough) Unwrap() Cache {
+	return c.cache
+}
+
+func (c *WriteThrough) Get(key string, value interface{}) error {
+	return c.cache.Get(key, value)
+}
+
+func (c *WriteThrough) GetBytes(key string) ([]byte, error) {
+	return c.cache.GetBytes(key)
+}
+
+// SetBytes is not supported, raw bytes cannot be handed to the writer
+func (c *WriteThrough) SetBytes(key string, bytes []byte, expiration ...time.Duration) error {
+	return ErrNotSupported
+}
+
+// Set persists value to the writer, then updates every tier. If the writer
+// fails the cache is left untouched. If a tier fails the key is evicted so
+// no tier keeps serving the previous value
+func (c *WriteThrough) Set(key string, value interface{}, expiration ...time.Duration) error {
+	if !isPtr(value) {
+		return ErrMustBePointer
+	}
+
+	if err := c.writer.Write(key, value); err != nil {
+		return err
+	}
+
+	if err := c.cache.Set(key, value, expiration...); err != nil {
+		if err := c.cache.Delete(key); err != nil {
+			DefaultLogger.Error("cache: evicting after failed write-through", "store", c.cache.Type(), "key", key, "error", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes key from the writer, then from every tier. If the writer
+// fails the cache is left untouched
+func (c *WriteThrough) Delete(key string) error {
+	if err := c.writer.Delete(key); err != nil {
+		return err
+	}
+
+	return c.cache.Delete(key)
+}
+
+func (c *WriteThrough) Exists(key string) (bool, error) {
+	return c.cache.Exists(key)
+}
+
+func (c *WriteThrough) TTL(key string) (time.Duration, error) {
+	return c.cache.TTL(key)
+}
+
+func (c *WriteThrough) Touch(key string, ttl time.Duration) error {
+	return c.cache.Touch(key, ttl)
+}
+
+// Clear only wipes the cache tiers, the system of record is left as is
+func (c *WriteThrough) Clear() error {
+	return c.cache.Clear()
+}
+
+func (c *WriteThrough) Scan(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	return c.cache.Scan(ctx, pattern, cursor, count)
+}
+
+// Ping checks the cache, the system of record is not checked
+func (c *WriteThrough) Ping(ctx context.Context) error {
+	return c.cache.Ping(ctx)
+}
+
+func (c *WriteThrough) Close() error {
+	return c.cache.Close()
+}
+
+func (c *WriteThrough) Type() string {
+	return "writethrough"
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteThrough(t *testing.T) {
+	var errSource = errors.New("source unavailable")
+	var source = map[string]string{}
+	var fail bool
+
+	var writer = WriterFuncs{
+		WriteFunc: func(key string, value interface{}) error {
+			if fail {
+				return errSource
+			}
+			source[key] = *value.(*string)
+			return nil
+		},
+		DeleteFunc: func(key string) error {
+			if fail {
+				return errSource
+			}
+			delete(source, key)
+			return nil
+		},
+	}
+
+	var l1 = NewMemoryStore(MemoryStoreOptions{})
+	var l2 = NewMemoryStore(MemoryStoreOptions{})
+	var wt Cache = NewWriteThrough(writer, NewChain(l1, l2))
+
+	var key = "test_write_through"
+	var strIn = "Hello world"
+	var err = wt.Set(key, &strIn)
+	assert.NoError(t, err)
+	assert.Equal(t, strIn, source[key])
+
+	var strOut string
+	assert.NoError(t, l1.Get(key, &strOut))
+	assert.Equal(t, strIn, strOut)
+	assert.NoError(t, l2.Get(key, &strOut))
+	assert.Equal(t, strIn, strOut)
+
+	// Source failure leaves the cache untouched
+	fail = true
+	var strNew = "Goodbye"
+	err = wt.Set(key, &strNew)
+	assert.Equal(t, errSource, err)
+	assert.NoError(t, wt.Get(key, &strOut))
+	assert.Equal(t, strIn, strOut)
+
+	err = wt.Delete(key)
+	assert.Equal(t, errSource, err)
+	assert.NoError(t, wt.Get(key, &strOut))
+
+	// Delete through
+	fail = false
+	assert.NoError(t, wt.Delete(key))
+	_, found := source[key]
+	assert.False(t, found)
+	assert.Equal(t, ErrKeyNotFound, wt.Get(key, &strOut))
+}